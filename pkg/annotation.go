@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	annotationTextColumn = "text"
+	annotationTagsColumn = "tags"
+)
+
+// annotationFrame maps the time, timeend, text and tags columns of an annotation query to
+// the fields Grafana reads annotations from. Tags are returned comma separated.
+func annotationFrame(frame *data.Frame, qm *dataQueryModel) (*data.Frame, error) {
+	if qm.timeIndex == -1 {
+		return nil, errors.New("no time column found")
+	}
+	timeField := frame.Fields[qm.timeIndex]
+	timeField.Name = "time"
+	annotations := data.NewFrame(frame.Name, timeField)
+	annotations.Meta = frame.Meta
+
+	if qm.timeEndIndex != -1 {
+		timeEndField := frame.Fields[qm.timeEndIndex]
+		timeEndField.Name = "timeEnd"
+		annotations.Fields = append(annotations.Fields, timeEndField)
+	}
+	if field, _ := frame.FieldByName(annotationTextColumn); field != nil {
+		annotations.Fields = append(annotations.Fields, stringField("text", field, func(s string) string { return s }))
+	}
+	if field, _ := frame.FieldByName(annotationTagsColumn); field != nil {
+		annotations.Fields = append(annotations.Fields, stringField("tags", field, normalizeTags))
+	}
+	return annotations, nil
+}
+
+// stringField returns the values of field as strings transformed by fn.
+func stringField(name string, field *data.Field, fn func(string) string) *data.Field {
+	values := make([]*string, field.Len())
+	for i := range values {
+		v, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		s := fn(fmt.Sprint(v))
+		values[i] = &s
+	}
+	return data.NewField(name, nil, values)
+}
+
+// normalizeTags trims the tags of a comma separated list and drops empty ones.
+func normalizeTags(s string) string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return strings.Join(tags, ",")
+}
+
+// scanTags converts a Presto array of tags to a comma separated list.
+func scanTags(in interface{}) (interface{}, error) {
+	v := *in.(*interface{})
+	if v == nil {
+		return nil, nil
+	}
+	elems, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected tags value of type %T", v)
+	}
+	tags := make([]string, 0, len(elems))
+	for _, elem := range elems {
+		if elem != nil {
+			tags = append(tags, fmt.Sprint(elem))
+		}
+	}
+	s := strings.Join(tags, ",")
+	return &s, nil
+}
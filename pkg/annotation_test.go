@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func annotationResult(tagsType string, tags ...interface{}) func(string) prestoResult {
+	return func(string) prestoResult {
+		return prestoResult{
+			columns: []prestoColumn{{"time", "timestamp(3)"}, {"timeend", "timestamp(3)"}, {"text", "varchar"}, {"tags", tagsType}, {"other", "bigint"}},
+			rows: [][]interface{}{
+				{"2022-01-01 00:00:00.000", "2022-01-01 00:10:00.000", "deploy v1", tags[0], 1},
+				{"2022-01-01 01:00:00.000", nil, nil, tags[1], 2},
+			},
+		}
+	}
+}
+
+func queryAnnotations(t *testing.T, presto *fakePresto) backend.DataResponse {
+	t.Helper()
+	ds := newTestDatasource(t, presto, nil)
+	query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT * FROM deployments", "format": "annotation"})
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{query}})
+	if err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	return resp.Responses["A"]
+}
+
+func TestQueryDataAnnotations(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagsType string
+		tags     []interface{}
+	}{
+		{name: "comma separated tags", tagsType: "varchar", tags: []interface{}{" deploy, api ,,", nil}},
+		{name: "array of tags", tagsType: "array(varchar)", tags: []interface{}{[]interface{}{"deploy", "api", nil}, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := queryAnnotations(t, newFakePresto(t, annotationResult(tt.tagsType, tt.tags...)))
+			if res.Error != nil {
+				t.Fatalf("query error = %v", res.Error)
+			}
+			frame := res.Frames[0]
+			var names []string
+			for _, field := range frame.Fields {
+				names = append(names, field.Name)
+			}
+			if got := strings.Join(names, ","); got != "time,timeEnd,text,tags" {
+				t.Fatalf("annotation fields = %s, want time,timeEnd,text,tags", got)
+			}
+			if v, _ := frame.Fields[0].ConcreteAt(1); v != time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC) {
+				t.Errorf("time of annotation 2 = %v", v)
+			}
+			if v, _ := frame.Fields[1].ConcreteAt(0); v != time.Date(2022, 1, 1, 0, 10, 0, 0, time.UTC) {
+				t.Errorf("end of annotation 1 = %v", v)
+			}
+			if _, ok := frame.Fields[1].ConcreteAt(1); ok {
+				t.Error("annotation 2 has an end, want none")
+			}
+			if v, _ := frame.Fields[2].ConcreteAt(0); v != "deploy v1" {
+				t.Errorf("text of annotation 1 = %v, want deploy v1", v)
+			}
+			if _, ok := frame.Fields[2].ConcreteAt(1); ok {
+				t.Error("annotation 2 has a text, want none")
+			}
+			if v, _ := frame.Fields[3].ConcreteAt(0); v != "deploy,api" {
+				t.Errorf("tags of annotation 1 = %q, want deploy,api", v)
+			}
+			if _, ok := frame.Fields[3].ConcreteAt(1); ok {
+				t.Error("annotation 2 has tags, want none")
+			}
+		})
+	}
+}
+
+func TestQueryDataAnnotationsWithoutTime(t *testing.T) {
+	presto := newFakePresto(t, func(string) prestoResult {
+		return prestoResult{columns: []prestoColumn{{"text", "varchar"}}, rows: [][]interface{}{{"deploy"}}}
+	})
+	if res := queryAnnotations(t, presto); res.Error == nil || !strings.Contains(res.Error.Error(), "no time column found") {
+		t.Errorf("query error = %v, want no time column", res.Error)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tests := map[string]string{
+		"a,b":         "a,b",
+		" a , b ":     "a,b",
+		"a,,b,":       "a,b",
+		"":            "",
+		" , ":         "",
+		"single tag ": "single tag",
+	}
+	for in, want := range tests {
+		if got := normalizeTags(in); got != want {
+			t.Errorf("normalizeTags(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestScanTags(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  interface{}
+	}{
+		{value: []interface{}{"a", "b"}, want: "a,b"},
+		{value: []interface{}{"a", nil, 1.5}, want: "a,1.5"},
+		{value: []interface{}{}, want: ""},
+		{value: nil, want: nil},
+	}
+	for _, tt := range tests {
+		in := tt.value
+		got, err := scanTags(&in)
+		if err != nil || derefString(got) != tt.want {
+			t.Errorf("scanTags(%v) = %v, %v, want %v", tt.value, derefString(got), err, tt.want)
+		}
+	}
+	var in interface{} = "a,b"
+	if _, err := scanTags(&in); err == nil {
+		t.Error("scanTags() of a string succeeded, want an error")
+	}
+}
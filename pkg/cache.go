@@ -0,0 +1,169 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const defaultCacheMaxBytes = 64 << 20
+
+// resultCache caches the frames of query results in memory, evicting the least recently used
+// results when the estimated size of all results exceeds maxBytes.
+type resultCache struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type resultCacheEntry struct {
+	key     string
+	frames  data.Frames
+	size    int64
+	created time.Time
+	expires time.Time
+}
+
+func newResultCache(maxBytes int64) *resultCache {
+	return &resultCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// get returns copies of the cached frames carrying a notice that they were served from cache.
+func (c *resultCache) get(key string) (data.Frames, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*resultCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+
+	frames := make(data.Frames, len(entry.frames))
+	notice := data.Notice{
+		Severity: data.NoticeSeverityInfo,
+		Text:     fmt.Sprintf("Served from cache, the result was queried at %s", entry.created.UTC().Format(time.RFC3339)),
+	}
+	for i, frame := range entry.frames {
+		frames[i] = shallowCopyFrame(frame)
+		frames[i].AppendNotices(notice)
+	}
+	return frames, true
+}
+
+func (c *resultCache) set(key string, frames data.Frames, ttl time.Duration) {
+	var size int64
+	for _, frame := range frames {
+		size += frameSize(frame)
+	}
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.remove(elem)
+	}
+	for c.size+size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+	now := time.Now()
+	c.items[key] = c.lru.PushFront(&resultCacheEntry{key: key, frames: frames, size: size, created: now, expires: now.Add(ttl)})
+	c.size += size
+}
+
+// remove deletes a cached result, the caller must hold mu.
+func (c *resultCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*resultCacheEntry)
+	delete(c.items, entry.key)
+	c.size -= entry.size
+}
+
+// queryResultKey identifies the result of a query for caching and deduplication. The query model
+// is part of the key, because it controls how the rows are processed into frames.
+func queryResultKey(ds *PrestoDatasource, opts connOptions, rawSql string, query backend.DataQuery, queryJson Query, fromAlert bool) string {
+	queryJson.RefId = ""
+	queryJson.RawSql = ""
+	queryJson.CacheTTLSeconds = 0
+	model, _ := json.Marshal(queryJson)
+	h := sha256.New()
+	for _, part := range []string{
+		ds.settings.Instance.Name,
+		opts.key(),
+		rawSql,
+		string(model),
+		query.TimeRange.From.UTC().Format(time.RFC3339Nano),
+		query.TimeRange.To.UTC().Format(time.RFC3339Nano),
+		query.Interval.String(),
+		strconv.FormatBool(fromAlert),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// alignTimeRange shifts a time range ending close to now back to a multiple of ttl,
+// so that repeated refreshes of a dashboard within ttl share a cache key. It must not
+// change the range of the query itself, which would lose the most recent data.
+func alignTimeRange(tr backend.TimeRange, ttl time.Duration) backend.TimeRange {
+	if time.Since(tr.To) > ttl {
+		return tr
+	}
+	offset := tr.To.Sub(tr.To.Truncate(ttl))
+	return backend.TimeRange{From: tr.From.Add(-offset), To: tr.To.Add(-offset)}
+}
+
+// cacheTTL returns how long the result of a query may be cached, zero disables caching.
+func (ds *PrestoDatasource) cacheTTL(queryJson Query) time.Duration {
+	seconds := ds.settings.PrestoParam.CacheTTLSeconds
+	if queryJson.CacheTTLSeconds != 0 {
+		seconds = queryJson.CacheTTLSeconds
+	} else if queryJson.Format == string(dataQueryFormatVariable) && seconds == 0 {
+		return variableCacheTTL
+	}
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func shallowCopyFrame(frame *data.Frame) *data.Frame {
+	copied := *frame
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		meta.Notices = append([]data.Notice(nil), frame.Meta.Notices...)
+		copied.Meta = &meta
+	}
+	return &copied
+}
+
+// frameSize estimates the memory used by the values of a frame.
+func frameSize(frame *data.Frame) int64 {
+	var size int64
+	for _, field := range frame.Fields {
+		for i := 0; i < field.Len(); i++ {
+			size += valueSize(field.At(i))
+		}
+	}
+	return size
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestAlignTimeRange(t *testing.T) {
+	to := time.Now().Truncate(time.Hour).Add(10 * time.Minute)
+	tr := alignTimeRange(backend.TimeRange{From: to.Add(-time.Hour), To: to}, time.Hour)
+	if want := to.Add(-10 * time.Minute); !tr.To.Equal(want) || !tr.From.Equal(want.Add(-time.Hour)) {
+		t.Errorf("alignTimeRange() = %v - %v, want the range ending at %v", tr.From, tr.To, want)
+	}
+
+	old := backend.TimeRange{From: to.Add(-3 * time.Hour), To: to.Add(-2 * time.Hour)}
+	if tr := alignTimeRange(old, time.Hour); tr != old {
+		t.Errorf("alignTimeRange() of a range ending before the ttl = %v, want it unchanged", tr)
+	}
+}
+
+func TestQueryDataCacheTimeRange(t *testing.T) {
+	presto := newFakePresto(t, oneRow)
+	ds := newTestDatasource(t, presto, map[string]interface{}{"CacheTTLSeconds": 3600})
+	base := time.Now().UTC().Truncate(time.Hour)
+	query := func(to time.Time) {
+		q := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT 1 WHERE $__timeFilter(ts)", "format": "table"})
+		q.TimeRange = backend.TimeRange{From: to.Add(-time.Hour), To: to}
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{q}})
+		if err != nil {
+			t.Fatalf("QueryData() error = %v", err)
+		}
+		if err := resp.Responses["A"].Error; err != nil {
+			t.Fatalf("query error = %v", err)
+		}
+	}
+
+	// a cache miss queries the real time range, refreshes within the ttl hit the cache
+	query(base.Add(10 * time.Minute))
+	query(base.Add(20 * time.Minute))
+	statements := presto.received()
+	if len(statements) != 1 {
+		t.Fatalf("Presto received %d queries, want 1", len(statements))
+	}
+	want := base.Add(10 * time.Minute).Format("2006-01-02T15:04:05.000Z")
+	if !strings.Contains(statements[0], want) {
+		t.Errorf("query %q does not end at the real end of the time range %s", statements[0], want)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// carrierTypes maps the types the presto driver fails to decode to a type of the same JSON encoding
+// which the driver does decode. The driver parses date and time values in the local time zone of
+// the plugin, fails on offsets and on more than millisecond precision, so they are passed as strings.
+var carrierTypes = map[string]string{
+	"row":                      "array",
+	"date":                     "varchar",
+	"time":                     "varchar",
+	"time with time zone":      "varchar",
+	"timestamp":                "varchar",
+	"timestamp with time zone": "varchar",
+	"uuid":                     "varchar",
+	"ipprefix":                 "varchar",
+	"hyperloglog":              "varchar",
+	"p4hyperloglog":            "varchar",
+	"qdigest":                  "varchar",
+	"tdigest":                  "varchar",
+	"kllsketch":                "varchar",
+	"setdigest":                "varchar",
+}
+
+var (
+	// carriedTypeRegex matches the type names of columns rewritten by columnTypeTransport.
+	carriedTypeRegex = regexp.MustCompile(`^[a-z]+\(carried:(.*)\)$`)
+	// typePrecisionRegex matches the precision of a type, e.g. (3) of timestamp(3) with time zone.
+	typePrecisionRegex = regexp.MustCompile(`\(\d+\)`)
+	// typeLengthSuffixRegex matches a trailing precision or length, which the driver drops from type names.
+	typeLengthSuffixRegex = regexp.MustCompile(`\(\d+\)$`)
+)
+
+// columnTypeTransport rewrites the types of result columns the presto driver fails to decode, e.g.
+// row(a integer, b varchar) becomes array(carried:row(a integer, b varchar)). The values are not
+// changed, databaseTypeName restores the original type name.
+type columnTypeTransport struct {
+	base http.RoundTripper
+}
+
+func (t *columnTypeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || !strings.HasPrefix(req.URL.Path, "/v1/statement") {
+		return resp, err
+	}
+	var rewritten bool
+	if resp.Body, rewritten = rewriteColumnTypes(resp.Body); rewritten {
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+	}
+	return resp, nil
+}
+
+// readCloser reads from a reader and closes a closer, e.g. the body a reader was built from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// rewriteColumnTypes rewrites the column types of a statement response body, rewritten is false if
+// there is nothing to rewrite. Presto sends the columns before the data, only the members up to the
+// data are decoded and the data is streamed as it is, so pages are never held in memory.
+func rewriteColumnTypes(body io.ReadCloser) (io.ReadCloser, bool) {
+	var consumed bytes.Buffer
+	d := json.NewDecoder(io.TeeReader(body, &consumed))
+	unchanged := &readCloser{Reader: io.MultiReader(&consumed, body), Closer: body}
+	if token, err := d.Token(); err != nil || token != json.Delim('{') {
+		return unchanged, false
+	}
+	var head bytes.Buffer
+	head.WriteByte('{')
+	rewritten := false
+	for d.More() {
+		token, err := d.Token()
+		key, ok := token.(string)
+		if err != nil || !ok {
+			return unchanged, false
+		}
+		if head.Len() > 1 {
+			head.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		head.Write(name)
+		if key == "data" {
+			// the decoder stopped before the colon of the data member
+			break
+		}
+		var value json.RawMessage
+		if err := d.Decode(&value); err != nil {
+			return unchanged, false
+		}
+		if key == "columns" {
+			if columns, ok := rewriteColumns(value); ok {
+				value, rewritten = columns, true
+			}
+		}
+		head.WriteByte(':')
+		head.Write(value)
+	}
+	if !rewritten {
+		return unchanged, false
+	}
+	return &readCloser{Reader: io.MultiReader(&head, d.Buffered(), body), Closer: body}, true
+}
+
+// rewriteColumns rewrites the types of the columns of a statement response, ok is false if there is nothing to rewrite.
+func rewriteColumns(raw json.RawMessage) (json.RawMessage, bool) {
+	var columns []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &columns); err != nil {
+		return nil, false
+	}
+	rewritten := false
+	for _, column := range columns {
+		var typeName string
+		if err := json.Unmarshal(column["type"], &typeName); err != nil {
+			continue
+		}
+		base, _ := splitType(typePrecisionRegex.ReplaceAllString(typeName, ""))
+		carrier, ok := carrierTypes[base]
+		if !ok {
+			continue
+		}
+		column["type"], _ = json.Marshal(fmt.Sprintf("%s(carried:%s)", carrier, typeName))
+		rewritten = true
+	}
+	if !rewritten {
+		return nil, false
+	}
+	b, err := json.Marshal(columns)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// databaseTypeName returns the Presto type of a result column. Like the driver it drops a
+// trailing precision or length, e.g. timestamp(6) becomes timestamp.
+func databaseTypeName(ct *sql.ColumnType) string {
+	name := ct.DatabaseTypeName()
+	if m := carriedTypeRegex.FindStringSubmatch(name); m != nil {
+		name = typeLengthSuffixRegex.ReplaceAllString(m[1], "")
+	}
+	return name
+}
+
+// splitType splits a type name into the lower case base type and its top level arguments,
+// e.g. map(varchar, array(double)) into map and [varchar, array(double)].
+func splitType(typeName string) (string, []string) {
+	open := strings.IndexByte(typeName, '(')
+	if open == -1 || !strings.HasSuffix(typeName, ")") {
+		return strings.ToLower(strings.TrimSpace(typeName)), nil
+	}
+	base := strings.ToLower(strings.TrimSpace(typeName[:open]))
+	var args []string
+	depth, start := 0, open+1
+	inQuote := false
+	for i := open + 1; i < len(typeName)-1; i++ {
+		switch c := typeName[i]; {
+		case c == '"':
+			inQuote = !inQuote
+		case inQuote:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(typeName[start:i]))
+			start = i + 1
+		}
+	}
+	return base, append(args, strings.TrimSpace(typeName[start:len(typeName)-1]))
+}
+
+// rowField is a field of a row type.
+type rowField struct {
+	name     string
+	typeName string
+}
+
+// parseRowType returns the fields of a row type, ok is false for other types. Anonymous
+// fields are named field0, field1 and so on, by their position.
+func parseRowType(typeName string) (fields []rowField, ok bool) {
+	base, args := splitType(typeName)
+	if base != "row" {
+		return nil, false
+	}
+	for i, arg := range args {
+		field := rowField{name: fmt.Sprintf("field%d", i), typeName: arg}
+		if strings.HasPrefix(arg, `"`) {
+			if end, err := quoteEnd(arg, 0); err == nil {
+				field.name = strings.ReplaceAll(arg[1:end-1], `""`, `"`)
+				field.typeName = strings.TrimSpace(arg[end:])
+			}
+		} else if space := strings.IndexByte(arg, ' '); space > 0 && !strings.ContainsAny(arg[:space], "()") {
+			field.name = arg[:space]
+			field.typeName = strings.TrimSpace(arg[space+1:])
+		}
+		fields = append(fields, field)
+	}
+	return fields, true
+}
+
+// scanJSON converts a scanned array or map to its JSON text. JSON values are returned in string
+// fields, the SDK version the plugin is built with has no JSON field type.
+func scanJSON(in interface{}) (interface{}, error) {
+	v := *in.(*interface{})
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
+
+// rowConverter returns a converter of the values of a row type to JSON objects keyed by the field names.
+// Presto sends rows as arrays of the field values in order.
+func rowConverter(fields []rowField) func(in interface{}) (interface{}, error) {
+	return func(in interface{}) (interface{}, error) {
+		v := *in.(*interface{})
+		if v == nil {
+			return nil, nil
+		}
+		values, ok := v.([]interface{})
+		if !ok || len(values) != len(fields) {
+			return nil, fmt.Errorf("unexpected row value %v", v)
+		}
+		var buf bytes.Buffer
+		buf.WriteByte('{')
+		for i, field := range fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			name, _ := json.Marshal(field.name)
+			value, err := json.Marshal(values[i])
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(name)
+			buf.WriteByte(':')
+			buf.Write(value)
+		}
+		buf.WriteByte('}')
+		s := buf.String()
+		return &s, nil
+	}
+}
+
+// explodeColumns replaces the map(varchar, ...) and row columns of frame by a field per key, named
+// column.key. Numeric and boolean values are kept as such, so in time series they become values
+// and the other values become labels. The column indices of qm are updated.
+func explodeColumns(frame *data.Frame, qm *dataQueryModel) (*data.Frame, error) {
+	index := make([]int, len(frame.Fields))
+	var fields []*data.Field
+	for i, field := range frame.Fields {
+		index[i] = len(fields)
+		var exploded []*data.Field
+		if i != qm.timeIndex && i != qm.timeEndIndex && i != qm.metricIndex && i < len(qm.columnTypes) {
+			var err error
+			if exploded, err = explodeField(field, databaseTypeName(qm.columnTypes[i])); err != nil {
+				return nil, fmt.Errorf("column %q: %w", field.Name, err)
+			}
+		}
+		if exploded == nil {
+			exploded = []*data.Field{field}
+		}
+		fields = append(fields, exploded...)
+	}
+	for _, i := range []*int{&qm.timeIndex, &qm.timeEndIndex, &qm.metricIndex} {
+		if *i != -1 {
+			*i = index[*i]
+		}
+	}
+	frame.Fields = fields
+	return frame, nil
+}
+
+// explodeField returns a field per key of the JSON objects of a map or row field, or nil for fields of other types.
+func explodeField(field *data.Field, typeName string) ([]*data.Field, error) {
+	var keys []string
+	keyTypes := map[string]string{}
+	rowFields, isRow := parseRowType(typeName)
+	valueType := ""
+	if isRow {
+		for _, f := range rowFields {
+			keys = append(keys, f.name)
+			keyTypes[f.name] = f.typeName
+		}
+	} else {
+		base, args := splitType(typeName)
+		if base != "map" || len(args) != 2 {
+			return nil, nil
+		}
+		if keyBase, _ := splitType(args[0]); keyBase != "varchar" && keyBase != "char" {
+			return nil, nil
+		}
+		valueType = args[1]
+	}
+
+	objects := make([]map[string]interface{}, field.Len())
+	for i := range objects {
+		s, ok := field.ConcreteAt(i)
+		if !ok {
+			continue
+		}
+		d := json.NewDecoder(strings.NewReader(s.(string)))
+		d.UseNumber()
+		if err := d.Decode(&objects[i]); err != nil {
+			return nil, err
+		}
+		if isRow {
+			continue
+		}
+		// the fields of a map are the keys of all rows, in order of appearance
+		for _, key := range sortedKeys(objects[i]) {
+			if _, ok := keyTypes[key]; !ok {
+				keys = append(keys, key)
+				keyTypes[key] = valueType
+			}
+		}
+	}
+
+	fields := make([]*data.Field, len(keys))
+	for k, key := range keys {
+		fieldType := explodedFieldType(keyTypes[key])
+		f := data.NewFieldFromFieldType(fieldType, len(objects))
+		f.Name = field.Name + "." + key
+		for i, object := range objects {
+			v, ok := object[key]
+			if !ok || v == nil {
+				continue
+			}
+			value, err := explodedValue(v, fieldType)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			f.Set(i, value)
+		}
+		fields[k] = f
+	}
+	return fields, nil
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// explodedFieldType returns the field type of the values of a map or row field of the Presto type.
+func explodedFieldType(typeName string) data.FieldType {
+	switch base, _ := splitType(typeName); base {
+	case "tinyint", "smallint", "integer", "bigint", "real", "double", "decimal":
+		return data.FieldTypeNullableFloat64
+	case "boolean":
+		return data.FieldTypeNullableBool
+	default:
+		return data.FieldTypeNullableString
+	}
+}
+
+// explodedValue converts a decoded JSON value to a value of the field type. Strings are kept,
+// other values of string fields become their JSON text.
+func explodedValue(v interface{}, fieldType data.FieldType) (interface{}, error) {
+	switch fieldType {
+	case data.FieldTypeNullableFloat64:
+		var f float64
+		var err error
+		switch v := v.(type) {
+		case json.Number:
+			f, err = v.Float64()
+		case string:
+			// decimals are sent as strings
+			f, err = strconv.ParseFloat(v, 64)
+		default:
+			err = fmt.Errorf("unexpected number %v", v)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &f, nil
+	case data.FieldTypeNullableBool:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("unexpected boolean %v", v)
+		}
+		return &b, nil
+	}
+	if s, ok := v.(string); ok {
+		return &s, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(b)
+	return &s, nil
+}
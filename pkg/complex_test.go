@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestParseRowType(t *testing.T) {
+	tests := []struct {
+		typeName string
+		want     []rowField
+		ok       bool
+	}{
+		{typeName: "row(a array(map(varchar,bigint)))", want: []rowField{{"a", "array(map(varchar,bigint))"}}, ok: true},
+		{typeName: "row(a integer, b row(c varchar, d double))", want: []rowField{{"a", "integer"}, {"b", "row(c varchar, d double)"}}, ok: true},
+		{typeName: `row("my field" bigint, "a""b" varchar, "x,y" decimal(10,2))`, want: []rowField{{"my field", "bigint"}, {`a"b`, "varchar"}, {"x,y", "decimal(10,2)"}}, ok: true},
+		{typeName: "row(bigint, map(varchar, double))", want: []rowField{{"field0", "bigint"}, {"field1", "map(varchar, double)"}}, ok: true},
+		{typeName: "ROW(a timestamp(3) with time zone)", want: []rowField{{"a", "timestamp(3) with time zone"}}, ok: true},
+		{typeName: "array(row(a bigint))"},
+		{typeName: "varchar"},
+	}
+	for _, tt := range tests {
+		got, ok := parseRowType(tt.typeName)
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRowType(%q) = %v, %v, want %v, %v", tt.typeName, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestSplitType(t *testing.T) {
+	tests := []struct {
+		typeName string
+		base     string
+		args     []string
+	}{
+		{typeName: "map(varchar, array(map(varchar,bigint)))", base: "map", args: []string{"varchar", "array(map(varchar,bigint))"}},
+		{typeName: `row("a(" bigint, "b)" double)`, base: "row", args: []string{`"a(" bigint`, `"b)" double`}},
+		{typeName: "Timestamp With Time Zone", base: "timestamp with time zone"},
+		{typeName: "decimal(38,0)", base: "decimal", args: []string{"38", "0"}},
+	}
+	for _, tt := range tests {
+		base, args := splitType(tt.typeName)
+		if base != tt.base || !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitType(%q) = %q, %q, want %q, %q", tt.typeName, base, args, tt.base, tt.args)
+		}
+	}
+}
+
+func TestRowConverter(t *testing.T) {
+	fields, _ := parseRowType(`row(a array(map(varchar,bigint)), "b c" varchar)`)
+	convert := rowConverter(fields)
+
+	var value interface{} = []interface{}{
+		[]interface{}{map[string]interface{}{"k": json.Number("1")}, nil},
+		"x",
+	}
+	got, err := convert(&value)
+	if err != nil {
+		t.Fatalf("convert() error = %v", err)
+	}
+	if want := `{"a":[{"k":1},null],"b c":"x"}`; *got.(*string) != want {
+		t.Errorf("convert() = %s, want %s", *got.(*string), want)
+	}
+
+	value = nil
+	if got, err := convert(&value); err != nil || got != nil {
+		t.Errorf("convert(nil) = %v, %v, want nil", got, err)
+	}
+	value = []interface{}{"too few"}
+	if _, err := convert(&value); err == nil {
+		t.Error("convert() of a row of the wrong length succeeded")
+	}
+}
+
+func TestRewriteColumnTypes(t *testing.T) {
+	rows := `[[[1,"a"],"2022-01-01 00:00:00.000 UTC",1]]`
+	body := `{"id":"q1","nextUri":"http://presto/v1/statement/q1/2","columns":[` +
+		`{"name":"r","type":"row(a bigint, b varchar)","typeSignature":{"rawType":"row"}},` +
+		`{"name":"t","type":"timestamp(3) with time zone"},` +
+		`{"name":"v","type":"bigint"}],"data":` + rows + `,"stats":{"state":"RUNNING"}}`
+
+	rewritten, ok := rewriteColumnTypes(io.NopCloser(strings.NewReader(body)))
+	if !ok {
+		t.Fatal("rewriteColumnTypes() rewrote nothing")
+	}
+	b, err := io.ReadAll(rewritten)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"data":`+rows+`,"stats":{"state":"RUNNING"}}`) {
+		t.Errorf("the data was not passed on as it is: %s", b)
+	}
+	var response queryPage
+	if err := json.Unmarshal(b, &response); err != nil {
+		t.Fatalf("rewritten response %s is invalid: %v", b, err)
+	}
+	wantTypes := []string{"array(carried:row(a bigint, b varchar))", "varchar(carried:timestamp(3) with time zone)", "bigint"}
+	for i, column := range response.Columns {
+		if column.Type != wantTypes[i] {
+			t.Errorf("type of column %d = %q, want %q", i, column.Type, wantTypes[i])
+		}
+	}
+	if response.ID != "q1" || response.NextURI != "http://presto/v1/statement/q1/2" || len(response.Data) != 1 {
+		t.Errorf("rewritten response = %+v", response)
+	}
+}
+
+func TestRewriteColumnTypesUnchanged(t *testing.T) {
+	for _, body := range []string{
+		`{"id":"q1","nextUri":"http://presto/v1/statement/q1/1","stats":{}}`,
+		`{"id":"q1", "columns": [{"name":"v","type":"bigint"}], "data": [[1]]}`,
+		`{"id":"q1","data":[[1]],"columns":[{"name":"r","type":"row(a bigint)"}]}`,
+		`not json`,
+	} {
+		rewritten, ok := rewriteColumnTypes(io.NopCloser(strings.NewReader(body)))
+		if ok {
+			t.Errorf("rewriteColumnTypes(%s) rewrote the response", body)
+		}
+		if b, err := io.ReadAll(rewritten); err != nil || string(b) != body {
+			t.Errorf("rewriteColumnTypes(%s) = %s, %v, want the body as it is", body, b, err)
+		}
+	}
+}
+
+func TestExplodeField(t *testing.T) {
+	field := data.NewField("m", nil, []*string{
+		stringPtr(`{"b":1,"a":2}`),
+		nil,
+		stringPtr(`{"c":3,"a":null}`),
+	})
+	fields, err := explodeField(field, "map(varchar, bigint)")
+	if err != nil {
+		t.Fatalf("explodeField() error = %v", err)
+	}
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Name)
+		if f.Type() != data.FieldTypeNullableFloat64 {
+			t.Errorf("field %s is %v, want numbers", f.Name, f.Type())
+		}
+	}
+	if want := []string{"m.a", "m.b", "m.c"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("explodeField() fields = %v, want %v", names, want)
+	}
+	wantValues := [][]interface{}{{2.0, nil, nil}, {1.0, nil, nil}, {nil, nil, 3.0}}
+	for i, f := range fields {
+		for row, want := range wantValues[i] {
+			got, _ := f.NullableFloatAt(row)
+			if (got == nil) != (want == nil) || (got != nil && *got != want.(float64)) {
+				t.Errorf("%s[%d] = %v, want %v", f.Name, row, formatFloat(got), want)
+			}
+		}
+	}
+}
+
+func TestExplodeFieldRow(t *testing.T) {
+	field := data.NewField("r", nil, []*string{
+		stringPtr(`{"cpu":"0.5","mem used":{"x":1},"up":true,"host":"a"}`),
+	})
+	fields, err := explodeField(field, `row(cpu decimal(3,2), "mem used" map(varchar,bigint), up boolean, host varchar)`)
+	if err != nil {
+		t.Fatalf("explodeField() error = %v", err)
+	}
+	if len(fields) != 4 {
+		t.Fatalf("explodeField() returned %d fields, want 4", len(fields))
+	}
+	if v, _ := fields[0].NullableFloatAt(0); fields[0].Name != "r.cpu" || v == nil || *v != 0.5 {
+		t.Errorf("decimal field %s = %v", fields[0].Name, formatFloat(v))
+	}
+	if v, _ := fields[1].ConcreteAt(0); fields[1].Name != "r.mem used" || v != `{"x":1}` {
+		t.Errorf("map field %s = %v, want its JSON", fields[1].Name, v)
+	}
+	if v, _ := fields[2].ConcreteAt(0); v != true {
+		t.Errorf("boolean field = %v", v)
+	}
+	if v, _ := fields[3].ConcreteAt(0); v != "a" {
+		t.Errorf("varchar field = %v", v)
+	}
+}
+
+func TestExplodeFieldOtherTypes(t *testing.T) {
+	field := data.NewField("m", nil, []*string{stringPtr(`{"1":2}`)})
+	for _, typeName := range []string{"map(bigint, bigint)", "array(varchar)", "varchar"} {
+		if fields, err := explodeField(field, typeName); fields != nil || err != nil {
+			t.Errorf("explodeField(%q) = %v, %v, want nil", typeName, fields, err)
+		}
+	}
+}
+
+func TestQueryDataExplode(t *testing.T) {
+	presto := newFakePresto(t, func(string) prestoResult {
+		return prestoResult{
+			columns: []prestoColumn{
+				{"time", "timestamp(3)"},
+				{"tags", "map(varchar, varchar)"},
+				{"stats", `row(cpu double, "mem used" bigint)`},
+			},
+			rows: [][]interface{}{
+				{"2022-01-01 00:00:00.000", map[string]interface{}{"host": "a"}, []interface{}{0.5, 10}},
+				{"2022-01-01 00:01:00.000", map[string]interface{}{"region": "eu", "host": "b"}, nil},
+			},
+			pageSize: 1,
+		}
+	})
+	ds := newTestDatasource(t, presto, nil)
+	query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT * FROM metrics", "format": "table", "explode": true})
+
+	resp, err := ds.QueryData(context.Background(), alertRequest(query))
+	if err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatalf("query error = %v", res.Error)
+	}
+	var names []string
+	for _, field := range res.Frames[0].Fields {
+		names = append(names, field.Name)
+	}
+	if want := []string{"time", "tags.host", "tags.region", "stats.cpu", "stats.mem used"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("fields = %v, want %v", names, want)
+	}
+	if v, _ := res.Frames[0].Fields[2].ConcreteAt(1); v != "eu" {
+		t.Errorf("tags.region of the second row = %v, want eu", v)
+	}
+	if v, _ := res.Frames[0].Fields[4].NullableFloatAt(0); v == nil || *v != 10 {
+		t.Errorf("stats.mem used of the first row = %v, want 10", formatFloat(v))
+	}
+}
+
+// queryPage is the part of a statement response checked by the tests.
+type queryPage struct {
+	ID      string          `json:"id"`
+	NextURI string          `json:"nextUri"`
+	Columns []prestoColumn  `json:"columns"`
+	Data    [][]interface{} `json:"data"`
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prestodb/presto-go-client/presto"
+)
+
+// maxConnVariants limits the number of connection variants kept open per datasource.
+const maxConnVariants = 64
+
+var sessionPropertyNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// connOptions are per query settings, which the presto driver only supports per connection.
+// Queries with non default options use a dedicated sql.DB, see dbFor.
+type connOptions struct {
+	user              string
+	clientInfo        string
+	clientTags        []string
+	sessionProperties map[string]string
+}
+
+func (o connOptions) isDefault() bool {
+	return o.user == "" && len(o.headers()) == 0 && len(o.sessionProperties) == 0
+}
+
+func (o connOptions) headers() map[string]string {
+	headers := map[string]string{}
+	if o.clientInfo != "" {
+		headers["X-Presto-Client-Info"] = o.clientInfo
+	}
+	if len(o.clientTags) > 0 {
+		headers["X-Presto-Client-Tags"] = strings.Join(o.clientTags, ",")
+	}
+	return headers
+}
+
+func (o connOptions) key() string {
+	var sb strings.Builder
+	sb.WriteString(url.QueryEscape(o.user))
+	writeSorted := func(prefix string, values map[string]string) {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sb.WriteString("&" + prefix + url.QueryEscape(name) + "=" + url.QueryEscape(values[name]))
+		}
+	}
+	writeSorted("header.", o.headers())
+	writeSorted("session.", o.sessionProperties)
+	return sb.String()
+}
+
+// sessionPropertiesParam returns the session properties of the connection as expected by the presto driver.
+func (o connOptions) sessionPropertiesParam(queryMaxExecutionSeconds int64) string {
+	properties := []string{fmt.Sprintf("query_max_execution_time=%ds", queryMaxExecutionSeconds)}
+	names := make([]string, 0, len(o.sessionProperties))
+	for name := range o.sessionProperties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		properties = append(properties, name+"="+o.sessionProperties[name])
+	}
+	return strings.Join(properties, ",")
+}
+
+// withQuery returns a copy of the options with the session properties and client tags of query,
+// which are validated against the allowlist of the datasource.
+func (ds *PrestoDatasource) withQuery(opts connOptions, query Query) (connOptions, error) {
+	if len(query.SessionProperties) > 0 {
+		opts.sessionProperties = make(map[string]string, len(query.SessionProperties))
+		for name, value := range query.SessionProperties {
+			if !ds.sessionPropertyAllowed(name) {
+				return opts, fmt.Errorf("session property %q is not allowed for this datasource", name)
+			}
+			if strings.ContainsAny(value, ",=\r\n") {
+				return opts, fmt.Errorf("invalid value %q of session property %q", value, name)
+			}
+			opts.sessionProperties[name] = value
+		}
+	}
+	if len(query.ClientTags) > 0 {
+		opts.clientTags = make([]string, 0, len(query.ClientTags))
+		for _, tag := range query.ClientTags {
+			tag = strings.TrimSpace(tag)
+			if tag == "" {
+				continue
+			}
+			if strings.ContainsAny(tag, ",\r\n") {
+				return opts, fmt.Errorf("invalid client tag %q", tag)
+			}
+			opts.clientTags = append(opts.clientTags, tag)
+		}
+		sort.Strings(opts.clientTags)
+	}
+	return opts, nil
+}
+
+func (ds *PrestoDatasource) sessionPropertyAllowed(name string) bool {
+	if !sessionPropertyNamePattern.MatchString(name) || name == "query_max_execution_time" {
+		return false
+	}
+	for _, allowed := range ds.settings.PrestoParam.AllowedSessionProperties {
+		if allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// prestoConn is a connection variant. It is closed once it has been evicted and the last query
+// using it released it, refs and evicted are guarded by connMu.
+type prestoConn struct {
+	db        *sql.DB
+	clientKey string
+	lastUsed  time.Time
+	refs      int
+	evicted   bool
+}
+
+// dsn returns the data source name of a connection with the given options,
+// using the custom client registered as clientKey.
+func (s *DatasourceSettings) dsn(opts connOptions, clientKey string) string {
+	user := opts.user
+	if user == "" {
+		user = s.Instance.BasicAuthUser
+	}
+	dsn := fmt.Sprintf("%s://%s@%s?catalog=%s&schema=%s&custom_client=%s&session_properties=%s",
+		s.PrestoParam.HTTPScheme,
+		url.User(user).String(),
+		s.PrestoParam.Host,
+		s.PrestoParam.Catalog,
+		s.PrestoParam.Schema,
+		url.QueryEscape(clientKey),
+		url.QueryEscape(opts.sessionPropertiesParam(s.PrestoParam.QueryMaxExecutionSeconds)),
+	)
+	params := url.Values{}
+	for _, param := range s.PrestoParam.CustomParams {
+		params.Add(param.Name, param.Value)
+	}
+	if len(params) > 0 {
+		dsn = dsn + "&" + params.Encode()
+	}
+	return dsn
+}
+
+// connOptionsFor returns the connection options for the queries of a Grafana user. Requests without
+// a user, like those of alert rules, run as the service user. Without a service user they run as the
+// datasource user, unless only mapped users are allowed.
+func (ds *PrestoDatasource) connOptionsFor(user *backend.User) (connOptions, error) {
+	param := ds.settings.PrestoParam
+	if !param.ForwardUser {
+		return connOptions{}, nil
+	}
+	if user == nil || user.Login == "" {
+		switch {
+		case param.ServiceUser != "":
+			return param.forwardedUser(param.ServiceUser), nil
+		case param.UserAllowlistOnly:
+			return connOptions{}, fmt.Errorf("requests without a grafana user are not allowed to query this datasource unless a service user is set")
+		}
+		return connOptions{}, nil
+	}
+
+	prestoUser, mapped := user.Login, false
+	for _, mapping := range param.UserMappings {
+		if mapping.GrafanaUser == user.Login {
+			prestoUser, mapped = mapping.PrestoUser, true
+			break
+		}
+	}
+	if param.UserAllowlistOnly && !mapped {
+		return connOptions{}, fmt.Errorf("grafana user %q is not allowed to query this datasource", user.Login)
+	}
+
+	return param.forwardedUser(prestoUser), nil
+}
+
+// forwardedUser returns the connection options running queries as the Presto user.
+func (p PrestoParam) forwardedUser(prestoUser string) connOptions {
+	if p.ForwardUserAsClientInfo {
+		return connOptions{clientInfo: prestoUser}
+	}
+	return connOptions{user: prestoUser}
+}
+
+// dbFor returns the sql.DB for queries with the given options. The caller must call release once
+// it is done with the db, including the rows of its queries.
+func (ds *PrestoDatasource) dbFor(opts connOptions) (db *sql.DB, release func(), err error) {
+	if opts.isDefault() {
+		return ds.db, func() {}, nil
+	}
+
+	key := opts.key()
+	ds.connMu.Lock()
+	defer ds.connMu.Unlock()
+	if conn, ok := ds.conns[key]; ok {
+		conn.lastUsed = time.Now()
+		return conn.db, ds.acquireConn(conn), nil
+	}
+	if len(ds.conns) >= maxConnVariants {
+		ds.evictOldestConn()
+	}
+
+	client := ds.client
+	if headers := opts.headers(); len(headers) > 0 {
+		client = &http.Client{Transport: &headerTransport{base: ds.client.Transport, headers: headers}}
+	}
+	// an evicted connection may still be in use, its client is deregistered when it's closed
+	ds.connSeq++
+	clientKey := fmt.Sprintf("%s#%x#%d", ds.settings.Instance.Name, sha256.Sum256([]byte(key)), ds.connSeq)
+	if err := presto.RegisterCustomClient(clientKey, client); err != nil {
+		return nil, nil, err
+	}
+	db, err = sql.Open("presto", ds.settings.dsn(opts, clientKey))
+	if err != nil {
+		presto.DeregisterCustomClient(clientKey)
+		return nil, nil, err
+	}
+	ds.settings.configurePool(db)
+	conn := &prestoConn{db: db, clientKey: clientKey, lastUsed: time.Now()}
+	ds.conns[key] = conn
+	return db, ds.acquireConn(conn), nil
+}
+
+// acquireConn counts a user of conn and returns the function releasing it, the caller must hold connMu.
+func (ds *PrestoDatasource) acquireConn(conn *prestoConn) func() {
+	conn.refs++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ds.connMu.Lock()
+			defer ds.connMu.Unlock()
+			if conn.refs--; conn.refs == 0 && conn.evicted {
+				conn.close()
+			}
+		})
+	}
+}
+
+// evictOldestConn removes the least recently used connection variant, the caller must hold connMu.
+// A connection still in use is closed when its last user releases it.
+func (ds *PrestoDatasource) evictOldestConn() {
+	var oldestKey string
+	var oldest *prestoConn
+	for key, conn := range ds.conns {
+		if oldest == nil || conn.lastUsed.Before(oldest.lastUsed) {
+			oldestKey, oldest = key, conn
+		}
+	}
+	if oldest != nil {
+		ds.evictConn(oldestKey, oldest)
+	}
+}
+
+func (ds *PrestoDatasource) closeConns() {
+	ds.connMu.Lock()
+	defer ds.connMu.Unlock()
+	for key, conn := range ds.conns {
+		ds.evictConn(key, conn)
+	}
+}
+
+// evictConn removes conn from the connection variants and closes it unless it is in use,
+// the caller must hold connMu.
+func (ds *PrestoDatasource) evictConn(key string, conn *prestoConn) {
+	delete(ds.conns, key)
+	conn.evicted = true
+	if conn.refs == 0 {
+		conn.close()
+	}
+}
+
+func (c *prestoConn) close() {
+	c.db.Close()
+	presto.DeregisterCustomClient(c.clientKey)
+}
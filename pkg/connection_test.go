@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func oneRow(string) prestoResult {
+	return prestoResult{columns: []prestoColumn{{"v", "bigint"}}, rows: [][]interface{}{{1}}}
+}
+
+func queryOne(ds *PrestoDatasource, opts connOptions) error {
+	rows, release, err := ds.queryRows(context.Background(), opts, "SELECT 1", ds.rowLimit(0))
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer rows.Close()
+	for rows.Next() {
+	}
+	return rows.Err()
+}
+
+func TestEvictedConnInUse(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, oneRow), nil)
+	userOpts := func(i int) connOptions { return connOptions{user: fmt.Sprintf("user%d", i)} }
+
+	db, release, err := ds.dbFor(userOpts(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first variant is the least recently used one and is evicted by the last
+	for i := 1; i <= maxConnVariants; i++ {
+		if err := queryOne(ds, userOpts(i)); err != nil {
+			t.Fatalf("query of user%d failed: %v", i, err)
+		}
+	}
+	if len(ds.conns) != maxConnVariants {
+		t.Fatalf("%d connection variants are open, want %d", len(ds.conns), maxConnVariants)
+	}
+	if _, ok := ds.conns[userOpts(0).key()]; ok {
+		t.Fatal("the least recently used connection variant was not evicted")
+	}
+
+	// the evicted variant keeps working until it is released, also next to a new variant of the same options
+	rows, err := db.QueryContext(context.Background(), "SELECT 1")
+	if err != nil {
+		t.Fatalf("query of the evicted connection failed: %v", err)
+	}
+	if err := queryOne(ds, userOpts(0)); err != nil {
+		t.Fatalf("query of a new connection of the evicted options failed: %v", err)
+	}
+	rows.Close()
+	release()
+	release()
+	if err := db.Ping(); err == nil {
+		t.Error("the evicted connection was not closed after its release")
+	}
+	if err := queryOne(ds, userOpts(0)); err != nil {
+		t.Errorf("query of the new connection failed after the evicted one was closed: %v", err)
+	}
+}
+
+func TestCloseConnsInUse(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, oneRow), nil)
+	db, release, err := ds.dbFor(connOptions{user: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.closeConns()
+	if len(ds.conns) != 0 {
+		t.Fatalf("%d connection variants are left", len(ds.conns))
+	}
+	if err := db.Ping(); err != nil {
+		t.Errorf("connection in use was closed: %v", err)
+	}
+	release()
+	if err := db.Ping(); err == nil {
+		t.Error("connection was not closed after its release")
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	ds := &PrestoDatasource{settings: &DatasourceSettings{PrestoParam: PrestoParam{
+		AllowedSessionProperties: []string{"query_priority", "hive.bucket_execution_enabled", "query_max_execution_time", "bad name"},
+	}}}
+	tests := []struct {
+		name       string
+		query      Query
+		properties map[string]string
+		tags       []string
+		err        string
+	}{
+		{name: "no options"},
+		{
+			name:       "allowed properties",
+			query:      Query{SessionProperties: map[string]string{"query_priority": "2", "hive.bucket_execution_enabled": "false"}},
+			properties: map[string]string{"query_priority": "2", "hive.bucket_execution_enabled": "false"},
+		},
+		{name: "property not in allowlist", query: Query{SessionProperties: map[string]string{"join_distribution_type": "BROADCAST"}}, err: "not allowed"},
+		{name: "catalog property not in allowlist", query: Query{SessionProperties: map[string]string{"hive.insert_existing_partitions_behavior": "OVERWRITE"}}, err: "not allowed"},
+		{name: "max execution time", query: Query{SessionProperties: map[string]string{"query_max_execution_time": "1h"}}, err: "not allowed"},
+		{name: "invalid name", query: Query{SessionProperties: map[string]string{"bad name": "1"}}, err: "not allowed"},
+		{name: "value with comma", query: Query{SessionProperties: map[string]string{"query_priority": "1,query_max_execution_time=1h"}}, err: "invalid value"},
+		{name: "value with equals sign", query: Query{SessionProperties: map[string]string{"query_priority": "a=b"}}, err: "invalid value"},
+		{name: "value with carriage return", query: Query{SessionProperties: map[string]string{"query_priority": "1\r"}}, err: "invalid value"},
+		{name: "value with newline", query: Query{SessionProperties: map[string]string{"query_priority": "1\nX-Presto-User: admin"}}, err: "invalid value"},
+		{name: "client tags", query: Query{ClientTags: []string{" team-b", "", "team-a "}}, tags: []string{"team-a", "team-b"}},
+		{name: "client tag with comma", query: Query{ClientTags: []string{"a,b"}}, err: "invalid client tag"},
+		{name: "client tag with newline", query: Query{ClientTags: []string{"a\nX-Presto-User: admin"}}, err: "invalid client tag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := ds.withQuery(connOptions{user: "alice"}, tt.query)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("withQuery() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("withQuery() error = %v", err)
+			}
+			if opts.user != "alice" {
+				t.Errorf("withQuery() user = %q, want alice", opts.user)
+			}
+			if len(opts.sessionProperties) != len(tt.properties) {
+				t.Errorf("withQuery() session properties = %v, want %v", opts.sessionProperties, tt.properties)
+			}
+			for name, value := range tt.properties {
+				if opts.sessionProperties[name] != value {
+					t.Errorf("withQuery() session properties = %v, want %v", opts.sessionProperties, tt.properties)
+				}
+			}
+			if strings.Join(opts.clientTags, ",") != strings.Join(tt.tags, ",") {
+				t.Errorf("withQuery() client tags = %q, want %q", opts.clientTags, tt.tags)
+			}
+		})
+	}
+}
+
+func TestQueryDataSessionHeaders(t *testing.T) {
+	presto := newFakePresto(t, oneRow)
+	ds := newTestDatasource(t, presto, map[string]interface{}{"AllowedSessionProperties": []string{"query_priority"}})
+	req := &backend.QueryDataRequest{Queries: []backend.DataQuery{
+		dataQuery(t, "A", map[string]interface{}{
+			"rawSql":            "SELECT 1",
+			"format":            "table",
+			"sessionProperties": map[string]string{"query_priority": "2"},
+			"clientTags":        []string{"team-b", "team-a"},
+		}),
+	}}
+	if _, err := ds.QueryData(context.Background(), req); err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	req.Queries = []backend.DataQuery{dataQuery(t, "B", map[string]interface{}{"rawSql": "SELECT 2", "format": "table"})}
+	if _, err := ds.QueryData(context.Background(), req); err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+
+	headers := presto.statementHeaders()
+	if len(headers) != 2 {
+		t.Fatalf("%d statements were received, want 2", len(headers))
+	}
+	if got, want := headers[0].Get("X-Presto-Session"), "query_max_execution_time=60s,query_priority=2"; got != want {
+		t.Errorf("X-Presto-Session of the query with options = %q, want %q", got, want)
+	}
+	if got, want := headers[0].Get("X-Presto-Client-Tags"), "team-a,team-b"; got != want {
+		t.Errorf("X-Presto-Client-Tags of the query with options = %q, want %q", got, want)
+	}
+	if got, want := headers[1].Get("X-Presto-Session"), "query_max_execution_time=60s"; got != want {
+		t.Errorf("X-Presto-Session of the query without options = %q, want %q", got, want)
+	}
+	if got := headers[1].Get("X-Presto-Client-Tags"); got != "" {
+		t.Errorf("X-Presto-Client-Tags of the query without options = %q, want none", got)
+	}
+}
+
+func TestQueryDataRejectsSessionProperty(t *testing.T) {
+	presto := newFakePresto(t, oneRow)
+	ds := newTestDatasource(t, presto, nil)
+	req := &backend.QueryDataRequest{Queries: []backend.DataQuery{
+		dataQuery(t, "A", map[string]interface{}{
+			"rawSql":            "SELECT 1",
+			"sessionProperties": map[string]string{"query_max_execution_time": "1h"},
+		}),
+	}}
+	resp, err := ds.QueryData(context.Background(), req)
+	if err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	if res := resp.Responses["A"]; res.Error == nil || !strings.Contains(res.Error.Error(), "not allowed") {
+		t.Errorf("query with session property error = %v, want not allowed", res.Error)
+	}
+	if received := presto.received(); len(received) != 0 {
+		t.Errorf("rejected query was sent to Presto: %q", received)
+	}
+}
+
+func TestConnOptionsFor(t *testing.T) {
+	alice := &backend.User{Login: "alice"}
+	bob := &backend.User{Login: "bob"}
+	tests := []struct {
+		name  string
+		param PrestoParam
+		user  *backend.User
+		want  connOptions
+		err   string
+	}{
+		{name: "not forwarded", param: PrestoParam{}, user: alice},
+		{name: "forwarded", param: PrestoParam{ForwardUser: true}, user: bob, want: connOptions{user: "bob"}},
+		{name: "mapped", param: PrestoParam{ForwardUser: true}, user: alice, want: connOptions{user: "presto_alice"}},
+		{name: "as client info", param: PrestoParam{ForwardUser: true, ForwardUserAsClientInfo: true}, user: alice, want: connOptions{clientInfo: "presto_alice"}},
+		{name: "without user", param: PrestoParam{ForwardUser: true}},
+		{name: "empty login", param: PrestoParam{ForwardUser: true}, user: &backend.User{}},
+		{name: "service user", param: PrestoParam{ForwardUser: true, ServiceUser: "alerting"}, want: connOptions{user: "alerting"}},
+		{name: "service user as client info", param: PrestoParam{ForwardUser: true, ForwardUserAsClientInfo: true, ServiceUser: "alerting"}, user: &backend.User{}, want: connOptions{clientInfo: "alerting"}},
+		{name: "allowlist mapped", param: PrestoParam{ForwardUser: true, UserAllowlistOnly: true}, user: alice, want: connOptions{user: "presto_alice"}},
+		{name: "allowlist not mapped", param: PrestoParam{ForwardUser: true, UserAllowlistOnly: true}, user: bob, err: `grafana user "bob" is not allowed`},
+		{name: "allowlist without user", param: PrestoParam{ForwardUser: true, UserAllowlistOnly: true}, err: "without a grafana user"},
+		{name: "allowlist empty login", param: PrestoParam{ForwardUser: true, UserAllowlistOnly: true}, user: &backend.User{}, err: "without a grafana user"},
+		{name: "allowlist service user", param: PrestoParam{ForwardUser: true, UserAllowlistOnly: true, ServiceUser: "alerting"}, want: connOptions{user: "alerting"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			param := tt.param
+			param.UserMappings = append(param.UserMappings, struct {
+				GrafanaUser string
+				PrestoUser  string
+			}{GrafanaUser: "alice", PrestoUser: "presto_alice"})
+			ds := &PrestoDatasource{settings: &DatasourceSettings{PrestoParam: param}}
+			got, err := ds.connOptionsFor(tt.user)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("connOptionsFor(%+v) error = %v, want %q", tt.user, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("connOptionsFor(%+v) error = %v", tt.user, err)
+			}
+			if got.key() != tt.want.key() {
+				t.Errorf("connOptionsFor(%+v) = %+v, want %+v", tt.user, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
+	"net"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -24,7 +27,8 @@ const (
 	timeFormat      = "15:04:05"
 )
 
-func Converters() []sqlutil.Converter {
+// Converters returns the converters of the Presto types, timestamps without time zone are in loc.
+func Converters(loc *time.Location) []sqlutil.Converter {
 	return []sqlutil.Converter{
 		{
 
@@ -162,22 +166,30 @@ func Converters() []sqlutil.Converter {
 			},
 		},
 		{
-			Name:           "handle decimal",
-			InputTypeRegex: regexp.MustCompile("decimal.*"),
-			InputScanType:  reflect.TypeOf(sql.NullString{}),
+			Name:          "handle json",
+			InputTypeName: "json",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
 			FrameConverter: sqlutil.FrameConverter{
-				FieldType: data.FieldTypeNullableFloat64,
-				ConverterFunc: func(in interface{}) (interface{}, error) {
-					ns := in.(*sql.NullString)
-					if !ns.Valid {
-						return nil, nil
-					}
-					v, err := strconv.ParseFloat(ns.String, 64)
-					if err != nil {
-						return nil, err
-					}
-					return &v, nil
-				},
+				FieldType:     data.FieldTypeNullableString,
+				ConverterFunc: scanString,
+			},
+		},
+		{
+			Name:           "handle array",
+			InputTypeRegex: regexp.MustCompile(`^array\(`),
+			InputScanType:  reflect.TypeOf((*interface{})(nil)).Elem(),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType:     data.FieldTypeNullableString,
+				ConverterFunc: scanJSON,
+			},
+		},
+		{
+			Name:           "handle map",
+			InputTypeRegex: regexp.MustCompile(`^map\(`),
+			InputScanType:  reflect.TypeOf((*interface{})(nil)).Elem(),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType:     data.FieldTypeNullableString,
+				ConverterFunc: scanJSON,
 			},
 		},
 		{
@@ -259,7 +271,7 @@ func Converters() []sqlutil.Converter {
 					if !ns.Valid {
 						return nil, nil
 					}
-					v, err := time.Parse(dateTimeFormat1, ns.String)
+					v, err := parseTimestamp(ns.String, loc)
 					if err == nil {
 						return &v, nil
 					}
@@ -276,9 +288,140 @@ func Converters() []sqlutil.Converter {
 						return &v, nil
 					}
 
-					return nil, err
+					return nil, fmt.Errorf("cannot parse timestamp %q", ns.String)
+				},
+			},
+		},
+		{
+			Name:           "handle timestamp with time zone",
+			InputTypeRegex: regexp.MustCompile(`^timestamp(\(\d+\))? with time zone$`),
+			InputScanType:  reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableTime,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					ns := in.(*sql.NullString)
+					if !ns.Valid {
+						return nil, nil
+					}
+					v, err := parseZonedTimestamp(ns.String)
+					if err != nil {
+						return nil, err
+					}
+					return &v, nil
+				},
+			},
+		},
+		{
+			Name:          "handle varbinary",
+			InputTypeName: "varbinary",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType:     data.FieldTypeNullableString,
+				ConverterFunc: scanBinary,
+			},
+		},
+		{
+			Name:          "handle uuid",
+			InputTypeName: "uuid",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					ns := in.(*sql.NullString)
+					if !ns.Valid {
+						return nil, nil
+					}
+					v := strings.ToLower(ns.String)
+					return &v, nil
+				},
+			},
+		},
+		{
+			Name:          "handle ipaddress",
+			InputTypeName: "ipaddress",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					ns := in.(*sql.NullString)
+					if !ns.Valid {
+						return nil, nil
+					}
+					ip := net.ParseIP(ns.String)
+					if ip == nil {
+						return nil, fmt.Errorf("cannot parse ip address %q", ns.String)
+					}
+					v := ip.String()
+					return &v, nil
+				},
+			},
+		},
+		{
+			Name:          "handle ipprefix",
+			InputTypeName: "ipprefix",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableString,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					ns := in.(*sql.NullString)
+					if !ns.Valid {
+						return nil, nil
+					}
+					_, prefix, err := net.ParseCIDR(ns.String)
+					if err != nil {
+						return nil, fmt.Errorf("cannot parse ip prefix %q", ns.String)
+					}
+					v := prefix.String()
+					return &v, nil
+				},
+			},
+		},
+		{
+			Name:          "handle interval day to second",
+			InputTypeName: "interval day to second",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableInt64,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					ns := in.(*sql.NullString)
+					if !ns.Valid {
+						return nil, nil
+					}
+					v, err := parseIntervalDayToSecond(ns.String)
+					if err != nil {
+						return nil, err
+					}
+					return &v, nil
+				},
+			},
+		},
+		{
+			Name:          "handle interval year to month",
+			InputTypeName: "interval year to month",
+			InputScanType: reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType: data.FieldTypeNullableInt64,
+				ConverterFunc: func(in interface{}) (interface{}, error) {
+					ns := in.(*sql.NullString)
+					if !ns.Valid {
+						return nil, nil
+					}
+					v, err := parseIntervalYearToMonth(ns.String)
+					if err != nil {
+						return nil, err
+					}
+					return &v, nil
 				},
 			},
 		},
+		{
+			Name:           "handle sketches",
+			InputTypeRegex: sketchTypeRegex,
+			InputScanType:  reflect.TypeOf(sql.NullString{}),
+			FrameConverter: sqlutil.FrameConverter{
+				FieldType:     data.FieldTypeNullableString,
+				ConverterFunc: scanString,
+			},
+		},
 	}
 }
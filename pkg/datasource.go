@@ -7,8 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strings"
 	"sync"
 	"time"
 
@@ -18,7 +16,6 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
-	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 )
 
 const DefaultQuery = "SELECT 1, 2, 3"
@@ -26,11 +23,26 @@ const DefaultQuery = "SELECT 1, 2, 3"
 type PrestoDatasource struct {
 	settings *DatasourceSettings
 	db       *sql.DB
+	client   *http.Client
+	tokens   *tokenService
+
+	resourceHandler backend.CallResourceHandler
+	schemaCache     *schemaCache
+	cache           *resultCache
+	inflight        *queryGroup
+	queue           *queryQueue
+
+	connMu  sync.Mutex
+	conns   map[string]*prestoConn
+	connSeq int
 }
 
 type DatasourceSettings struct {
 	Instance    backend.DataSourceInstanceSettings
 	PrestoParam PrestoParam
+
+	// location is the session time zone, UTC if none is set.
+	location *time.Location
 }
 
 type PrestoParam struct {
@@ -44,13 +56,62 @@ type PrestoParam struct {
 	}
 	RowLimit                 int64
 	ResultRowLimit           int64
+	ResultMaxBytes           int64
 	QueryMaxExecutionSeconds int64
+	AuthType                 string
+	AllowInsecureAuth        bool
+	TLSSkipVerify            bool
+	TLSAuth                  bool
+	TLSAuthWithCACert        bool
+	OAuth2TokenURL           string
+	OAuth2Scopes             string
+	ForwardUser              bool
+	ForwardUserAsClientInfo  bool
+	UserAllowlistOnly        bool
+	ServiceUser              string
+	UserMappings             []struct {
+		GrafanaUser string
+		PrestoUser  string
+	}
+	AllowedSessionProperties []string
+	CacheTTLSeconds          int64
+	CacheMaxBytes            int64
+	MaxConcurrentQueries     int
+	QueueTimeoutSeconds      int64
+	MaxOpenConns             int
+	MaxIdleConns             int
+	ConnMaxLifetime          int64
+	VariableMaxValues        int
+	ReadOnly                 bool
+	SessionTimeZone          string
 }
 
 type Query struct {
-	RefId  string `json:"refId"`
-	RawSql string `json:"rawSql"`
-	Format string `json:"format"`
+	RefId           string  `json:"refId"`
+	RawSql          string  `json:"rawSql"`
+	Format          string  `json:"format"`
+	Fill            bool    `json:"fill"`
+	FillInterval    float64 `json:"fillInterval"`
+	FillMode        string  `json:"fillMode"`
+	FillValue       float64 `json:"fillValue"`
+	FillAggregation string  `json:"fillAggregation"`
+
+	SessionProperties map[string]string `json:"sessionProperties"`
+	ClientTags        []string          `json:"clientTags"`
+	CacheTTLSeconds   int64             `json:"cacheTTLSeconds"`
+	VariableSort      int               `json:"variableSort"`
+	// RowLimit lowers the row limits of the datasource for this query.
+	RowLimit int64 `json:"rowLimit"`
+	// Explode replaces map and row columns by a column per key.
+	Explode bool `json:"explode"`
+	// Decimals sets how decimal columns of tables are returned: float, string or scaled.
+	Decimals string `json:"decimals"`
+	// TimeColumn names the time column, TimeFormat is the format of string time columns.
+	TimeColumn string `json:"timeColumn"`
+	TimeFormat string `json:"timeFormat"`
+
+	// TemplateVariables are the values of the template variables referenced by RawSql.
+	TemplateVariables map[string][]string `json:"templateVariables"`
 }
 
 func NewDatasourceInstance(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
@@ -66,41 +127,86 @@ func NewDatasourceInstance(settings backend.DataSourceInstanceSettings) (instanc
 	if dsSettings.PrestoParam.ResultRowLimit < 0 {
 		dsSettings.PrestoParam.ResultRowLimit = 0
 	}
+	if dsSettings.PrestoParam.ResultMaxBytes <= 0 {
+		dsSettings.PrestoParam.ResultMaxBytes = 256 << 20
+	}
+	if dsSettings.PrestoParam.CacheMaxBytes <= 0 {
+		dsSettings.PrestoParam.CacheMaxBytes = defaultCacheMaxBytes
+	}
 	if dsSettings.PrestoParam.QueryMaxExecutionSeconds <= 0 {
 		dsSettings.PrestoParam.QueryMaxExecutionSeconds = 60
 	}
-	dsn := fmt.Sprintf("%s://%s@%s?catalog=%s&schema=%s&custom_client=%s&session_properties=query_max_execution_time=%ds",
-		dsSettings.PrestoParam.HTTPScheme,
-		dsSettings.Instance.BasicAuthUser,
-		dsSettings.PrestoParam.Host,
-		dsSettings.PrestoParam.Catalog,
-		dsSettings.PrestoParam.Schema,
-		dsSettings.Instance.Name,
-		dsSettings.PrestoParam.QueryMaxExecutionSeconds,
-	)
-	params := url.Values{}
-	for _, param := range dsSettings.PrestoParam.CustomParams {
-		params.Add(param.Name, param.Value)
-	}
-	if len(params) > 0 {
-		dsn = dsn + "&" + params.Encode()
+	if dsSettings.PrestoParam.VariableMaxValues <= 0 {
+		dsSettings.PrestoParam.VariableMaxValues = defaultVariableMaxValues
+	}
+	if dsSettings.PrestoParam.QueueTimeoutSeconds <= 0 {
+		dsSettings.PrestoParam.QueueTimeoutSeconds = dsSettings.PrestoParam.QueryMaxExecutionSeconds
+	}
+	dsSettings.location = time.UTC
+	if zone := dsSettings.PrestoParam.SessionTimeZone; zone != "" {
+		loc, err := loadZone(zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session time zone: %w", err)
+		}
+		dsSettings.location = loc
+	}
+	if err := dsSettings.checkAuthScheme(); err != nil {
+		return nil, err
+	}
+	dsn := dsSettings.dsn(connOptions{}, dsSettings.Instance.Name)
+	var tokens *tokenService
+	if dsSettings.PrestoParam.AuthType == authTypeOAuth2 {
+		tokenClient, err := newTokenClient(&dsSettings)
+		if err != nil {
+			return nil, err
+		}
+		secure := dsSettings.Instance.DecryptedSecureJSONData
+		tokens, err = newTokenService(dsSettings.PrestoParam.OAuth2TokenURL, secure["clientId"], secure["clientSecret"],
+			dsSettings.PrestoParam.OAuth2Scopes, tokenClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client, err := newHTTPClient(&dsSettings, tokens)
+	if err != nil {
+		return nil, err
 	}
 	db, err := sql.Open("presto", dsn)
 	if err != nil {
 		return nil, err
 	}
-	presto.RegisterCustomClient(dsSettings.Instance.Name, &http.Client{})
+	dsSettings.configurePool(db)
+	if err := presto.RegisterCustomClient(dsSettings.Instance.Name, client); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if tokens != nil {
+		tokens.Start()
+	}
 	backend.Logger.Info("Create datasource.", "datasource", dsSettings.Instance.Name, "url", dsn)
-	return &PrestoDatasource{
-		settings: &dsSettings,
-		db:       db,
-	}, nil
+	ds := &PrestoDatasource{
+		settings:    &dsSettings,
+		db:          db,
+		client:      client,
+		tokens:      tokens,
+		schemaCache: newSchemaCache(),
+		cache:       newResultCache(dsSettings.PrestoParam.CacheMaxBytes),
+		inflight:    newQueryGroup(),
+		queue:       newQueryQueue(dsSettings.Instance.Name, dsSettings.PrestoParam.MaxConcurrentQueries),
+		conns:       map[string]*prestoConn{},
+	}
+	ds.resourceHandler = ds.newResourceHandler()
+	return ds, nil
 }
 
 func (ds *PrestoDatasource) Dispose() {
 	backend.Logger.Info("Dispose datasource.", "datasource", ds.settings.Instance.Name)
 	ds.db.Close()
 	presto.DeregisterCustomClient(ds.settings.Instance.Name)
+	ds.closeConns()
+	if ds.tokens != nil {
+		ds.tokens.Close()
+	}
 }
 
 func (ds *PrestoDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
@@ -109,6 +215,15 @@ func (ds *PrestoDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 		return nil, err
 	}
 	result := backend.NewQueryDataResponse()
+	opts, err := ds.connOptionsFor(req.PluginContext.User)
+	if err != nil {
+		for _, query := range req.Queries {
+			result.Responses[query.RefID] = backend.DataResponse{Error: err}
+		}
+		return result, nil
+	}
+	// Unified alerting marks its requests, their series keep the labels alert instances are built from.
+	fromAlert := req.Headers["FromAlert"] == "true"
 	ch := make(chan DBDataResponse, len(req.Queries))
 	var wg sync.WaitGroup
 	for _, query := range req.Queries {
@@ -118,7 +233,7 @@ func (ds *PrestoDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 			return onErr(fmt.Errorf("unable to parse json %s. Error: %w", query.JSON, err))
 		}
 		wg.Add(1)
-		go ds.queryData(query, &wg, ctx, ch, queryJson)
+		go ds.queryData(query, &wg, ctx, ch, queryJson, opts, fromAlert)
 	}
 	wg.Wait()
 	close(ch)
@@ -139,11 +254,12 @@ func (ds *PrestoDatasource) CheckHealth(ctx context.Context, req *backend.CheckH
 	}
 	backend.Logger.Info(fmt.Sprintf("Starting HealthCheck, req:%v", req))
 
-	rows, err := ds.queryRows(DefaultQuery)
+	rows, release, err := ds.queryRows(ctx, connOptions{}, DefaultQuery, ds.rowLimit(0))
 	if err != nil {
 		return onErr(err)
 	}
 	rows.Close()
+	release()
 
 	return &backend.CheckHealthResult{
 		Status:  backend.HealthStatusOk,
@@ -152,7 +268,7 @@ func (ds *PrestoDatasource) CheckHealth(ctx context.Context, req *backend.CheckH
 }
 
 func (ds *PrestoDatasource) queryData(query backend.DataQuery, wg *sync.WaitGroup, queryContext context.Context,
-	ch chan DBDataResponse, queryJson Query) {
+	ch chan DBDataResponse, queryJson Query, opts connOptions, fromAlert bool) {
 	defer wg.Done()
 	queryResult := DBDataResponse{
 		dataResponse: backend.DataResponse{},
@@ -163,95 +279,186 @@ func (ds *PrestoDatasource) queryData(query backend.DataQuery, wg *sync.WaitGrou
 		queryError.Add(1)
 		backend.Logger.Error(fmt.Sprintf("Datasource query error: %s", err))
 	}
+	var cacheKey string
+	cacheTTL := ds.cacheTTL(queryJson)
 	defer func(start time.Time) {
 		if r := recover(); r != nil {
 			backend.Logger.Error("executeQuery panic", "error", r)
 			queryResult.dataResponse.Error = fmt.Errorf("%v", r)
+		} else if cacheKey != "" && queryResult.dataResponse.Error == nil {
+			ds.cache.set(cacheKey, queryResult.dataResponse.Frames, cacheTTL)
 		}
 		queryPrestoCost.Observe(float64(time.Since(start).Milliseconds()))
 		ch <- queryResult
 	}(time.Now())
-	rows, err := ds.queryRows(queryJson.RawSql)
+
+	interpolated, err := interpolateVariables(queryJson.RawSql, queryJson.TemplateVariables)
+	if err != nil {
+		onErr(pkgErrors.Wrap(err, "interpolate template variables failed"))
+		return
+	}
+
+	macros := newMacroEngine(query)
+	rawSql, err := macros.Interpolate(interpolated)
+	if err != nil {
+		onErr(pkgErrors.Wrap(err, "interpolate macros failed"))
+		return
+	}
+
+	// the query runs for its real time range, only the cache key uses the aligned one
+	keySql, keyQuery := rawSql, query
+	if cacheTTL > 0 {
+		keyQuery.TimeRange = alignTimeRange(query.TimeRange, cacheTTL)
+		if keySql, err = newMacroEngine(keyQuery).Interpolate(interpolated); err != nil {
+			onErr(pkgErrors.Wrap(err, "interpolate macros failed"))
+			return
+		}
+	}
+
+	opts, err = ds.withQuery(opts, queryJson)
 	if err != nil {
 		onErr(err)
 		return
 	}
+
+	key := queryResultKey(ds, opts, keySql, keyQuery, queryJson, fromAlert)
+	if cacheTTL > 0 {
+		if frames, ok := ds.cache.get(key); ok {
+			queryCacheHit.WithLabelValues(ds.settings.Instance.Name).Inc()
+			queryResult.dataResponse.Frames = frames
+			return
+		}
+		queryCacheMiss.WithLabelValues(ds.settings.Instance.Name).Inc()
+		cacheKey = key
+	}
+
+	frames, shared, err := ds.inflight.do(queryContext, key, func(ctx context.Context) (data.Frames, error) {
+		return ds.executeQuery(ctx, query, opts, rawSql, macros, ds.rowLimit(queryJson.RowLimit), fromAlert)
+	})
+	if err != nil {
+		onErr(ds.contextError(queryContext, err))
+		return
+	}
+	if shared {
+		queryCoalesced.WithLabelValues(ds.settings.Instance.Name).Inc()
+		// the caller which ran the query caches the result
+		cacheKey = ""
+	}
+	queryResult.dataResponse.Frames = frames
+}
+
+// executeQuery runs the expanded SQL of query and processes the rows into frames.
+func (ds *PrestoDatasource) executeQuery(ctx context.Context, query backend.DataQuery, opts connOptions, rawSql string,
+	macros *macroEngine, limit rowLimit, fromAlert bool) (data.Frames, error) {
+	if err := ds.queue.acquire(ctx, ds.queueTimeout()); err != nil {
+		return nil, err
+	}
+	defer ds.queue.release()
+
+	// Cancelling ctx makes database/sql close the rows, which in turn makes the presto
+	// driver DELETE the statement so that the query stops running on the cluster.
+	ctx, cancel := context.WithTimeout(ctx, ds.queryTimeout())
+	defer cancel()
+
+	rows, release, err := ds.queryRows(ctx, opts, rawSql, limit)
+	if err != nil {
+		return nil, ds.contextError(ctx, err)
+	}
+	defer release()
 	defer func() {
 		if err := rows.Close(); err != nil {
 			backend.Logger.Warn("Failed to close rows.", "err", err.Error())
 		}
 	}()
 
-	qm, err := newProcessCfg(query, queryContext, rows)
+	qm, err := newProcessCfg(query, ctx, rows)
 	if err != nil {
-		onErr(err)
-		return
+		return nil, err
+	}
+	qm.location = ds.settings.location
+	if macros.fillMissing != nil {
+		qm.FillMissing = macros.fillMissing
+		qm.Interval = macros.fillInterval
 	}
 
 	// Convert row.Rows to dataframe
-	frame, err := sqlutil.FrameFromRows(rows, ds.settings.PrestoParam.RowLimit, Converters()...)
+	frame, err := frameFromRows(rows, qm, limit, ds.settings.PrestoParam.ResultMaxBytes)
 	if err != nil {
-		onErr(err)
-		return
+		return nil, ds.contextError(ctx, err)
 	}
 
 	if frame.Meta == nil {
 		frame.Meta = &data.FrameMeta{}
 	}
 
-	frame.Meta.ExecutedQueryString = queryJson.RawSql
+	frame.Meta.ExecutedQueryString = rawSql
+
+	if qm.Format == dataQueryFormatVariable {
+		return data.Frames{variableFrame(frame, qm.VariableSort, ds.settings.PrestoParam.VariableMaxValues)}, nil
+	}
 
 	// If no rows were returned, no point checking anything else.
 	if frame.Rows() == 0 {
-		queryResult.dataResponse.Frames = data.Frames{frame}
-		return
+		return data.Frames{frame}, nil
 	}
 
 	if err := convertSQLTimeColumnsToEpochMS(frame, qm); err != nil {
-		onErr(err)
-		return
+		return nil, err
+	}
+
+	if qm.Explode && (qm.Format == dataQueryFormatSeries || qm.Format == dataQueryFormatTable) {
+		if frame, err = explodeColumns(frame, qm); err != nil {
+			return nil, err
+		}
+	}
+
+	if qm.Format == dataQueryFormatAnnotation {
+		annotations, err := annotationFrame(frame, qm)
+		if err != nil {
+			return nil, err
+		}
+		return data.Frames{annotations}, nil
 	}
 
 	if qm.Format == dataQueryFormatSeries {
 		// time series has to have time column
 		if qm.timeIndex == -1 {
-			onErr(errors.New("no time column found"))
-			return
+			return nil, errors.New("no time column found")
 		}
 
 		// Make sure to name the time field 'Time' to be backward compatible with Grafana pre-v8.
 		frame.Fields[qm.timeIndex].Name = data.TimeSeriesTimeFieldName
 
-		for i := range qm.columnNames {
+		for i := range frame.Fields {
 			if i == qm.timeIndex || i == qm.metricIndex {
 				continue
 			}
 
-			if t := frame.Fields[i].Type(); t == data.FieldTypeString || t == data.FieldTypeNullableString {
+			// string and bool columns are the labels of the series
+			switch frame.Fields[i].Type() {
+			case data.FieldTypeString, data.FieldTypeNullableString, data.FieldTypeBool, data.FieldTypeNullableBool:
 				continue
 			}
 
 			var err error
 			if frame, err = convertSQLValueColumnToFloat(frame, i); err != nil {
-				onErr(pkgErrors.Wrap(err, "convert value to float failed"))
-				return
+				return nil, pkgErrors.Wrap(err, "convert value to float failed")
 			}
 		}
 
 		tsSchema := frame.TimeSeriesSchema()
 		if tsSchema.Type == data.TimeSeriesTypeLong {
 			var err error
-			originalData := frame
-			frame, err = data.LongToWide(frame, qm.FillMissing)
+			originalData := sortFrameByTime(frame, tsSchema.TimeIndex)
+			frame, err = data.LongToWide(originalData, qm.FillMissing)
 			if err != nil {
-				pkgErrors.Wrap(err, "failed to convert long to wide series when converting from dataframe")
-				return
+				return nil, pkgErrors.Wrap(err, "failed to convert long to wide series when converting from dataframe")
 			}
 
 			// Before 8x, a special metric column was used to name time series. The LongToWide transforms that into a metric label on the value field.
 			// But that makes series name have both the value column name AND the metric name. So here we are removing the metric label here and moving it to the
-			// field name to get the same naming for the series as pre v8
-			if len(originalData.Fields) == 3 {
+			// field name to get the same naming for the series as pre v8. Alert queries keep the label, alert instances are told apart by labels.
+			if len(originalData.Fields) == 3 && !fromAlert {
 				for _, field := range frame.Fields {
 					if len(field.Labels) == 1 { // 7x only supported one label
 						name, ok := field.Labels["metric"]
@@ -272,21 +479,87 @@ func (ds *PrestoDatasource) queryData(query backend.DataQuery, wg *sync.WaitGrou
 			}
 		}
 	}
-	queryResult.dataResponse.Frames = data.Frames{frame}
+	return data.Frames{frame}, nil
 }
 
-func (ds *PrestoDatasource) queryRows(query string) (*sql.Rows, error) {
-	onErr := func(err error) (*sql.Rows, error) {
+// queryRows runs query with the connection of opts. The caller must call release after closing the rows.
+func (ds *PrestoDatasource) queryRows(ctx context.Context, opts connOptions, query string, limit rowLimit) (rows *sql.Rows, release func(), err error) {
+	onErr := func(err error) (*sql.Rows, func(), error) {
 		backend.Logger.Error(fmt.Sprintf("presto client query error: %v", err))
-		return nil, err
+		return nil, nil, err
 	}
-	if strings.TrimSpace(query) != "" && ds.settings.PrestoParam.ResultRowLimit > 0 {
-		query = fmt.Sprintf("SELECT * FROM ( %s ) query_limit_wrapper limit %d", query, ds.settings.PrestoParam.ResultRowLimit)
+	statement, err := ds.checkStatement(query)
+	if err != nil {
+		return onErr(err)
 	}
-	backend.Logger.Info("Query presto.", "datasource", ds.settings.Instance.Name, "query", query)
-	rows, err := ds.db.Query(query)
+	// One more row than the limit is queried, so that readers can tell whether the result was truncated.
+	// Statements which can't be limited in SQL are limited by the readers only.
+	query = statement.text
+	if limit.pushDown {
+		query, _ = statement.withLimit(limit.rows + 1)
+	}
+	db, release, err := ds.dbFor(opts)
 	if err != nil {
-		return onErr(fmt.Errorf("do presto query failed, err: %s", err.Error()))
+		return onErr(err)
+	}
+	backend.Logger.Info("Query presto.", "datasource", ds.settings.Instance.Name, "user", opts.user, "query", query)
+	rows, err = db.QueryContext(ctx, query)
+	if err != nil {
+		release()
+		return onErr(fmt.Errorf("do presto query failed, err: %w", err))
+	}
+	return rows, release, nil
+}
+
+// rowLimit is the max number of rows read of a result and the setting it comes from.
+type rowLimit struct {
+	rows   int64
+	source string
+	// pushDown is set for limits which are added to the SQL of the query.
+	pushDown bool
+}
+
+// rowLimit returns the lowest of the row limits of the datasource and the row limit of a query,
+// queryLimit is ignored unless it is positive.
+func (ds *PrestoDatasource) rowLimit(queryLimit int64) rowLimit {
+	limit := rowLimit{rows: ds.settings.PrestoParam.RowLimit, source: "row limit of the datasource"}
+	if resultLimit := ds.settings.PrestoParam.ResultRowLimit; resultLimit > 0 && resultLimit <= limit.rows {
+		limit = rowLimit{rows: resultLimit, source: "result row limit of the datasource", pushDown: true}
+	}
+	if queryLimit > 0 && queryLimit < limit.rows {
+		limit = rowLimit{rows: queryLimit, source: "row limit of the query", pushDown: true}
+	}
+	return limit
+}
+
+func (ds *PrestoDatasource) queryTimeout() time.Duration {
+	return time.Duration(ds.settings.PrestoParam.QueryMaxExecutionSeconds) * time.Second
+}
+
+func (ds *PrestoDatasource) queueTimeout() time.Duration {
+	return time.Duration(ds.settings.PrestoParam.QueueTimeoutSeconds) * time.Second
+}
+
+// configurePool applies the connection pool settings of the datasource to db.
+func (s *DatasourceSettings) configurePool(db *sql.DB) {
+	if s.PrestoParam.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(s.PrestoParam.MaxOpenConns)
+	}
+	if s.PrestoParam.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(s.PrestoParam.MaxIdleConns)
+	}
+	if s.PrestoParam.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(time.Duration(s.PrestoParam.ConnMaxLifetime) * time.Second)
+	}
+}
+
+// contextError explains err in terms of ctx when the query was aborted by Grafana or timed out.
+func (ds *PrestoDatasource) contextError(ctx context.Context, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("query exceeded the max execution time of %ds: %w", ds.settings.PrestoParam.QueryMaxExecutionSeconds, err)
+	case context.Canceled:
+		return fmt.Errorf("query cancelled: %w", err)
 	}
-	return rows, nil
+	return err
 }
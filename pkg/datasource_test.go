@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// seriesResult is a result of the columns time, host and value.
+func seriesResult(string) prestoResult {
+	return prestoResult{
+		columns: []prestoColumn{{"time", "timestamp(3)"}, {"host", "varchar"}, {"value", "bigint"}},
+		rows: [][]interface{}{
+			{"2022-01-01 00:00:00.000", "a", 1},
+			{"2022-01-01 00:00:00.000", "b", 2},
+			{"2022-01-01 00:01:00.000", "a", 3},
+			{"2022-01-01 00:01:00.000", "b", 4},
+		},
+	}
+}
+
+func alertRequest(queries ...backend.DataQuery) *backend.QueryDataRequest {
+	for i := range queries {
+		queries[i].TimeRange = backend.TimeRange{
+			From: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC),
+		}
+		queries[i].Interval = time.Minute
+		queries[i].MaxDataPoints = 100
+	}
+	return &backend.QueryDataRequest{
+		Headers: map[string]string{"FromAlert": "true"},
+		Queries: queries,
+	}
+}
+
+func TestQueryDataAlertWithoutFormat(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, seriesResult), nil)
+	query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT time, host, value FROM metrics"})
+
+	resp, err := ds.QueryData(context.Background(), alertRequest(query))
+	if err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatalf("query error = %v", res.Error)
+	}
+	if len(res.Frames) != 1 {
+		t.Fatalf("query returned %d frames, want 1", len(res.Frames))
+	}
+	frame := res.Frames[0]
+	if got := frame.TimeSeriesSchema().Type; got != data.TimeSeriesTypeWide {
+		t.Fatalf("frame is %v, want a wide series", got)
+	}
+	if len(frame.Fields) != 3 {
+		t.Fatalf("frame has %d fields, want time and a value per host", len(frame.Fields))
+	}
+	for i, host := range []string{"a", "b"} {
+		field := frame.Fields[i+1]
+		if field.Type() != data.FieldTypeNullableFloat64 {
+			t.Errorf("field %d is %v, want numbers", i+1, field.Type())
+		}
+		if got := field.Labels["host"]; got != host {
+			t.Errorf("field %d has host label %q, want %q", i+1, got, host)
+		}
+	}
+	if v, _ := frame.Fields[2].NullableFloatAt(1); v == nil || *v != 4 {
+		t.Errorf("last value of host b = %v, want 4", v)
+	}
+}
+
+func TestQueryDataAlertLabels(t *testing.T) {
+	presto := newFakePresto(t, func(string) prestoResult {
+		return prestoResult{
+			columns: []prestoColumn{{"time", "timestamp"}, {"metric", "varchar"}, {"value", "double"}},
+			rows: [][]interface{}{
+				{"2022-01-01 00:00:00.000", "cpu", 1.5},
+				{"2022-01-01 00:01:00.000", "cpu", 2.5},
+			},
+		}
+	})
+	ds := newTestDatasource(t, presto, nil)
+
+	for _, fromAlert := range []bool{false, true} {
+		query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT time, metric, value FROM metrics", "format": "time_series"})
+		req := alertRequest(query)
+		if !fromAlert {
+			req.Headers = nil
+		}
+		resp, err := ds.QueryData(context.Background(), req)
+		if err != nil {
+			t.Fatalf("QueryData() error = %v", err)
+		}
+		res := resp.Responses["A"]
+		if res.Error != nil || len(res.Frames) != 1 || len(res.Frames[0].Fields) != 2 {
+			t.Fatalf("QueryData(fromAlert=%v) = %+v", fromAlert, res)
+		}
+		field := res.Frames[0].Fields[1]
+		if fromAlert {
+			// alert instances are told apart by their labels
+			if field.Name != "value" || field.Labels["metric"] != "cpu" {
+				t.Errorf("alert series is %q %v, want value with metric label", field.Name, field.Labels)
+			}
+		} else if field.Name != "cpu" || len(field.Labels) != 0 {
+			t.Errorf("panel series is %q %v, want cpu without labels", field.Name, field.Labels)
+		}
+	}
+}
+
+func TestQueryDataUnknownFormat(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, seriesResult), nil)
+	req := alertRequest(
+		dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT time, host, value FROM metrics", "format": "heatmap"}),
+		dataQuery(t, "B", map[string]interface{}{"rawSql": "SELECT time, host, value FROM metrics"}),
+	)
+
+	resp, err := ds.QueryData(context.Background(), req)
+	if err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	if res := resp.Responses["A"]; res.Error == nil || !strings.Contains(res.Error.Error(), "heatmap") {
+		t.Errorf("query with unknown format error = %v", res.Error)
+	}
+	if res := resp.Responses["B"]; res.Error != nil || len(res.Frames) != 1 {
+		t.Errorf("query without format = %+v, want a frame", res)
+	}
+}
+
+func TestQueryDataCancelDeletesQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonData map[string]interface{}
+		cancel   bool
+		err      string
+	}{
+		{name: "cancelled", cancel: true, err: "query cancelled"},
+		{name: "timed out", jsonData: map[string]interface{}{"QueryMaxExecutionSeconds": 1}, err: "max execution time of 1s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hold := make(chan struct{})
+			defer close(hold)
+			presto := newFakePresto(t, func(string) prestoResult {
+				result := seriesResult("")
+				result.pageSize = 1
+				result.hold = hold
+				return result
+			})
+			ds := newTestDatasource(t, presto, tt.jsonData)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			done := make(chan backend.DataResponse)
+			go func() {
+				query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT time, host, value FROM metrics", "format": "table"})
+				resp, err := ds.QueryData(ctx, &backend.QueryDataRequest{Queries: []backend.DataQuery{query}})
+				if err != nil {
+					done <- backend.DataResponse{Error: err}
+					return
+				}
+				done <- resp.Responses["A"]
+			}()
+			// the second page is held, so the query is still running on Presto
+			waitFor(t, "second page", func() bool { return len(presto.requestsOf(http.MethodGet)) >= 2 })
+			if tt.cancel {
+				cancel()
+			}
+
+			res := <-done
+			if res.Error == nil || !strings.Contains(res.Error.Error(), tt.err) {
+				t.Errorf("QueryData() error = %v, want %q", res.Error, tt.err)
+			}
+			waitFor(t, "DELETE of the query", func() bool {
+				deletes := presto.requestsOf(http.MethodDelete)
+				return len(deletes) == 1 && strings.HasPrefix(deletes[0], "/v1/statement/executing/query1/")
+			})
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// How the decimal columns of table queries are returned, time series always use floats.
+const (
+	decimalsFloat  = "float"
+	decimalsString = "string"
+	decimalsScaled = "scaled"
+)
+
+// maxExactFloat is the largest magnitude up to which float64 represents every integer.
+const maxExactFloat = 1 << 53
+
+// parseDecimalType returns the precision and scale of a decimal type, ok is false for other types.
+func parseDecimalType(typeName string) (precision int, scale int, ok bool) {
+	base, args := splitType(typeName)
+	if base != "decimal" {
+		return 0, 0, false
+	}
+	// decimal without arguments is decimal(38,0)
+	precision, scale = 38, 0
+	if len(args) > 0 {
+		precision, _ = strconv.Atoi(args[0])
+	}
+	if len(args) > 1 {
+		scale, _ = strconv.Atoi(args[1])
+	}
+	return precision, scale, true
+}
+
+// checkDecimalsMode returns an error for an unknown decimals mode, an empty mode means float.
+func checkDecimalsMode(mode string) error {
+	switch mode {
+	case "", decimalsFloat, decimalsString, decimalsScaled:
+		return nil
+	}
+	return fmt.Errorf("unrecognized decimals mode: %q", mode)
+}
+
+// decimalColumn sets up col to scan a decimal column and returns the field type. Decimals become
+// float64, unless mode keeps them exact as strings or as int64 scaled by 10^scale. Decimals of more
+// than 18 digits don't fit int64 and are kept as strings.
+func decimalColumn(col *frameColumn, precision int, scale int, mode string) (data.FieldType, error) {
+	if err := checkDecimalsMode(mode); err != nil {
+		return data.FieldTypeUnknown, err
+	}
+	col.dest = &sql.NullString{}
+	if mode == decimalsScaled && precision > 18 {
+		mode = decimalsString
+	}
+	switch mode {
+	case decimalsString:
+		col.convert = scanString
+		return data.FieldTypeNullableString, nil
+	case decimalsScaled:
+		col.convert = func(in interface{}) (interface{}, error) {
+			ns := in.(*sql.NullString)
+			if !ns.Valid {
+				return nil, nil
+			}
+			v, err := scaleDecimal(ns.String, scale)
+			if err != nil {
+				return nil, err
+			}
+			return &v, nil
+		}
+		col.config = &data.FieldConfig{
+			Description: fmt.Sprintf("Values are scaled by 10^%d", scale),
+			Unit:        fmt.Sprintf("suffix:e-%d", scale),
+			Custom:      map[string]interface{}{"scale": scale},
+		}
+		return data.FieldTypeNullableInt64, nil
+	default:
+		col.convert = func(in interface{}) (interface{}, error) {
+			ns := in.(*sql.NullString)
+			if !ns.Valid {
+				return nil, nil
+			}
+			v, err := strconv.ParseFloat(ns.String, 64)
+			if err != nil {
+				return nil, err
+			}
+			if !col.lossy && significantDigits(ns.String) > 15 {
+				col.lossy = true
+			}
+			return &v, nil
+		}
+		return data.FieldTypeNullableFloat64, nil
+	}
+}
+
+// scaleDecimal returns a decimal like -123.45 as an integer scaled by 10^scale, e.g. -12345 for scale 2.
+func scaleDecimal(s string, scale int) (int64, error) {
+	digits, fraction := s, ""
+	if dot := strings.IndexByte(s, '.'); dot != -1 {
+		digits, fraction = s[:dot], s[dot+1:]
+	}
+	if len(fraction) > scale {
+		return 0, fmt.Errorf("decimal %q has more than %d fractional digits", s, scale)
+	}
+	v, err := strconv.ParseInt(digits+fraction+strings.Repeat("0", scale-len(fraction)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot scale decimal %q: %w", s, err)
+	}
+	return v, nil
+}
+
+// significantDigits counts the digits of a decimal without leading and trailing zeros.
+func significantDigits(s string) int {
+	digits := strings.Replace(strings.TrimLeft(s, "+-"), ".", "", 1)
+	return len(strings.TrimRight(strings.TrimLeft(digits, "0"), "0"))
+}
+
+// floatCaster returns a cast of numbers to float64 for time series, which marks col lossy when an
+// integer is too large to be represented exactly.
+func floatCaster(col *frameColumn) func(v interface{}) (interface{}, error) {
+	return func(v interface{}) (interface{}, error) {
+		if i, ok := v.(*int64); ok && i != nil && !col.lossy && (*i > maxExactFloat || *i < -maxExactFloat) {
+			col.lossy = true
+		}
+		return castToFloat64(v), nil
+	}
+}
+
+// lossyNotice is the notice of a column which lost precision in the conversion to float64.
+func lossyNotice(name string) data.Notice {
+	return data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text:     fmt.Sprintf("Values of column %q lost precision in the conversion to floating point numbers", name),
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestDecimalColumn(t *testing.T) {
+	tests := []struct {
+		mode      string
+		precision int
+		value     string
+		fieldType data.FieldType
+		want      interface{}
+		lossy     bool
+	}{
+		{mode: "", precision: 10, value: "123.45", fieldType: data.FieldTypeNullableFloat64, want: 123.45},
+		{mode: decimalsFloat, precision: 10, value: "-123.45", fieldType: data.FieldTypeNullableFloat64, want: -123.45},
+		{mode: decimalsFloat, precision: 20, value: "1234567890123456.78", fieldType: data.FieldTypeNullableFloat64, want: 1234567890123456.78, lossy: true},
+		{mode: decimalsString, precision: 20, value: "1234567890123456.78", fieldType: data.FieldTypeNullableString, want: "1234567890123456.78"},
+		{mode: decimalsScaled, precision: 10, value: "-123.4", fieldType: data.FieldTypeNullableInt64, want: int64(-12340)},
+		{mode: decimalsScaled, precision: 20, value: "1234567890123456.78", fieldType: data.FieldTypeNullableString, want: "1234567890123456.78"},
+	}
+	for _, tt := range tests {
+		col := &frameColumn{}
+		fieldType, err := decimalColumn(col, tt.precision, 2, tt.mode)
+		if err != nil || fieldType != tt.fieldType {
+			t.Errorf("decimalColumn(%q) = %v, %v, want %v", tt.mode, fieldType, err, tt.fieldType)
+			continue
+		}
+		v, err := col.convert(&sql.NullString{String: tt.value, Valid: true})
+		if err != nil {
+			t.Errorf("convert(%q) of mode %q error = %v", tt.value, tt.mode, err)
+			continue
+		}
+		var got interface{}
+		switch v := v.(type) {
+		case *float64:
+			got = *v
+		case *int64:
+			got = *v
+		case *string:
+			got = *v
+		}
+		if got != tt.want || col.lossy != tt.lossy {
+			t.Errorf("convert(%q) of mode %q = %v, lossy %v, want %v, lossy %v", tt.value, tt.mode, got, col.lossy, tt.want, tt.lossy)
+		}
+		if v, err := col.convert(&sql.NullString{}); err != nil || v != nil {
+			t.Errorf("convert(NULL) of mode %q = %v, %v, want nil", tt.mode, v, err)
+		}
+	}
+}
+
+func TestDecimalColumnUnknownMode(t *testing.T) {
+	for _, mode := range []string{"Float", "exact", "double"} {
+		if _, err := decimalColumn(&frameColumn{}, 10, 2, mode); err == nil || !strings.Contains(err.Error(), "unrecognized decimals mode") {
+			t.Errorf("decimalColumn(%q) error = %v, want an unrecognized mode", mode, err)
+		}
+	}
+}
+
+func TestScaleDecimal(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   string
+	}{
+		{value: "123.45", want: 12345},
+		{value: "-0.5", want: -50},
+		{value: "7", want: 700},
+		{value: "1.234", err: "more than 2 fractional digits"},
+		{value: "999999999999999999", err: "cannot scale"},
+	}
+	for _, tt := range tests {
+		got, err := scaleDecimal(tt.value, 2)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("scaleDecimal(%q) = %d, %v, want error %q", tt.value, got, err, tt.err)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("scaleDecimal(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func decimalResult(string) prestoResult {
+	return prestoResult{columns: []prestoColumn{{"price", "decimal(10,2)"}}, rows: [][]interface{}{{"12.34"}}}
+}
+
+func TestQueryDataUnknownDecimals(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, decimalResult), nil)
+	for _, format := range []string{"table", "time_series"} {
+		query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT price FROM t", "format": format, "decimals": "exact"})
+		resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{query}})
+		if err != nil {
+			t.Fatalf("QueryData() error = %v", err)
+		}
+		if err := resp.Responses["A"].Error; err == nil || !strings.Contains(err.Error(), `unrecognized decimals mode: "exact"`) {
+			t.Errorf("query of format %s error = %v, want an unrecognized decimals mode", format, err)
+		}
+	}
+}
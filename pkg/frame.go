@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+)
+
+// frameColumn scans one column of the result into its field.
+type frameColumn struct {
+	field *data.Field
+	dest  interface{}
+	// convert parses the scanned value, cast converts the parsed value to the type of the field, if needed.
+	convert func(in interface{}) (interface{}, error)
+	cast    func(v interface{}) (interface{}, error)
+	config  *data.FieldConfig
+	// lossy is set once a value lost precision in the conversion to float64.
+	lossy bool
+}
+
+// frameFromRows reads rows into a frame in a single pass. Unlike sqlutil.FrameFromRows it stores
+// the value columns of time series as float64 and the time columns as time.Time while scanning,
+// so that large results are not copied once more column by column. Reading stops with a notice
+// when the row limit is reached while more rows exist, or the estimated size of the frame exceeds maxBytes.
+func frameFromRows(rows *sql.Rows, qm *dataQueryModel, limit rowLimit, maxBytes int64) (*data.Frame, error) {
+	columns, err := newFrameColumns(qm)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]*data.Field, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i, col := range columns {
+		fields[i] = col.field
+		dest[i] = col.dest
+	}
+	frame := data.NewFrame("", fields...)
+
+	values := make([]interface{}, len(columns))
+	var count, size int64
+	for rows.Next() {
+		if count == limit.rows {
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Results have been limited to %v rows by the %s, the query returned more rows", limit.rows, limit.source),
+			})
+			break
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		var rowSize int64
+		for i, col := range columns {
+			v, err := col.convert(col.dest)
+			if err != nil {
+				return nil, fmt.Errorf("column %q: %w", col.field.Name, err)
+			}
+			if col.cast != nil {
+				if v, err = col.cast(v); err != nil {
+					return nil, fmt.Errorf("column %q: %w", col.field.Name, err)
+				}
+			}
+			values[i] = v
+			rowSize += valueSize(v)
+		}
+		if maxBytes > 0 && size+rowSize > maxBytes {
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Results have been limited to %v rows because the result exceeded the memory limit of %v bytes", count, maxBytes),
+			})
+			break
+		}
+		for i, col := range columns {
+			col.field.Append(values[i])
+		}
+		size += rowSize
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return frame, err
+	}
+	for _, col := range columns {
+		if col.lossy {
+			frame.AppendNotices(lossyNotice(col.field.Name))
+		}
+	}
+	return frame, nil
+}
+
+func newFrameColumns(qm *dataQueryModel) ([]*frameColumn, error) {
+	seen := map[string]int{}
+	for i, name := range qm.columnNames {
+		if j, ok := seen[name]; ok {
+			return nil, fmt.Errorf(`duplicate column names are not allowed, found identical name "%v" at column indices %v and %v`, name, j, i)
+		}
+		seen[name] = i
+	}
+
+	converters := Converters(qm.location)
+	columns := make([]*frameColumn, len(qm.columnNames))
+	for i, name := range qm.columnNames {
+		col := &frameColumn{dest: &sql.NullString{}, convert: scanString}
+		fieldType := data.FieldTypeNullableString
+		typeName := databaseTypeName(qm.columnTypes[i])
+		if qm.Format == dataQueryFormatAnnotation && name == annotationTagsColumn && strings.HasPrefix(typeName, "array") {
+			col.dest = new(interface{})
+			col.convert = scanTags
+		} else if fields, ok := parseRowType(typeName); ok {
+			col.dest = new(interface{})
+			col.convert = rowConverter(fields)
+		} else if precision, scale, ok := parseDecimalType(typeName); ok {
+			mode := qm.Decimals
+			if qm.Format != dataQueryFormatTable {
+				mode = decimalsFloat
+			}
+			var err error
+			if fieldType, err = decimalColumn(col, precision, scale, mode); err != nil {
+				return nil, err
+			}
+		} else if converter := findConverter(converters, typeName); converter != nil {
+			col.dest = reflect.New(converter.InputScanType).Interface()
+			col.convert = converter.FrameConverter.ConverterFunc
+			fieldType = converter.FrameConverter.FieldType
+			if config, ok := typeFieldConfigs[typeName]; ok {
+				copied := *config
+				col.config = &copied
+			}
+		}
+
+		switch {
+		case i == qm.timeIndex || i == qm.timeEndIndex:
+			if fieldType != data.FieldTypeNullableTime {
+				if !fieldType.Numeric() && fieldType != data.FieldTypeNullableString {
+					return nil, fmt.Errorf("column type %q is not convertible to time.Time", fieldType)
+				}
+				col.cast = epochTimeCaster(qm.timeLayout, qm.location)
+				fieldType = data.FieldTypeNullableTime
+			}
+		case qm.Format == dataQueryFormatSeries && i != qm.metricIndex && fieldType.Numeric():
+			if fieldType != data.FieldTypeNullableFloat64 {
+				col.cast = floatCaster(col)
+				fieldType = data.FieldTypeNullableFloat64
+			}
+		}
+
+		col.field = data.NewFieldFromFieldType(fieldType, 0)
+		col.field.Name = name
+		col.field.Config = col.config
+		columns[i] = col
+	}
+	return columns, nil
+}
+
+// sortFrameByTime returns frame with its rows sorted ascending by the time field, as LongToWide requires.
+// Rows with a null time come first. frame is returned as is if it is sorted already.
+func sortFrameByTime(frame *data.Frame, timeIndex int) *data.Frame {
+	timeField := frame.Fields[timeIndex]
+	timeAt := func(i int) time.Time {
+		if v, ok := timeField.ConcreteAt(i); ok {
+			return v.(time.Time)
+		}
+		return time.Time{}
+	}
+
+	rows := timeField.Len()
+	sorted := true
+	for i := 1; i < rows && sorted; i++ {
+		sorted = !timeAt(i).Before(timeAt(i - 1))
+	}
+	if sorted {
+		return frame
+	}
+
+	order := make([]int, rows)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return timeAt(order[a]).Before(timeAt(order[b]))
+	})
+	result := frame.EmptyCopy()
+	result.Meta = frame.Meta
+	for i, field := range frame.Fields {
+		sortedField := result.Fields[i]
+		sortedField.Config = field.Config
+		sortedField.Extend(rows)
+		for j, row := range order {
+			sortedField.Set(j, field.At(row))
+		}
+	}
+	return result
+}
+
+// findConverter returns the converter for columns of the database type, the same way sqlutil.MakeScanRow does.
+func findConverter(converters []sqlutil.Converter, typeName string) *sqlutil.Converter {
+	for i, c := range converters {
+		if c.InputTypeRegex != nil && c.InputTypeRegex.MatchString(typeName) {
+			return &converters[i]
+		}
+		if c.InputTypeName == typeName {
+			return &converters[i]
+		}
+	}
+	return nil
+}
+
+func scanString(in interface{}) (interface{}, error) {
+	ns := in.(*sql.NullString)
+	if !ns.Valid {
+		return nil, nil
+	}
+	v := ns.String
+	return &v, nil
+}
+
+func castToFloat64(v interface{}) interface{} {
+	var f float64
+	switch v := v.(type) {
+	case *int8:
+		f = float64(*v)
+	case *int16:
+		f = float64(*v)
+	case *int32:
+		f = float64(*v)
+	case *int64:
+		f = float64(*v)
+	case *float32:
+		f = float64(*v)
+	case *float64:
+		f = *v
+	default:
+		return nil
+	}
+	return &f
+}
+
+// epochTimeCaster returns a cast of epoch values and time strings to time, see parseTimeString.
+func epochTimeCaster(layout string, loc *time.Location) func(v interface{}) (interface{}, error) {
+	return func(v interface{}) (interface{}, error) {
+		if s, ok := v.(*string); ok {
+			t, err := parseTimeString(*s, layout, loc)
+			if err != nil {
+				return nil, err
+			}
+			return &t, nil
+		}
+		f, ok := castToFloat64(v).(*float64)
+		if !ok {
+			return nil, nil
+		}
+		t := time.Unix(0, int64(epochPrecisionToMS(*f))*int64(time.Millisecond))
+		return &t, nil
+	}
+}
+
+// valueSize estimates the memory used by a value of a nullable field: the pointer and the value it points to.
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 8
+	case *string:
+		return 8 + 16 + int64(len(*v))
+	case *time.Time:
+		return 8 + 24
+	default:
+		return 8 + 8
+	}
+}
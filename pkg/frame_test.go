@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+var trailingLimitPattern = regexp.MustCompile(`LIMIT (\d+)$`)
+
+// numbersResult returns a handler answering with total rows of numbers, fewer if the SQL ends with a LIMIT.
+func numbersResult(total int) func(sql string) prestoResult {
+	return func(sql string) prestoResult {
+		n := total
+		if m := trailingLimitPattern.FindStringSubmatch(sql); m != nil {
+			if limit, _ := strconv.Atoi(m[1]); limit < n {
+				n = limit
+			}
+		}
+		result := prestoResult{columns: []prestoColumn{{"n", "bigint"}, {"s", "varchar"}}}
+		for i := 0; i < n; i++ {
+			result.rows = append(result.rows, []interface{}{i, strings.Repeat("x", 100)})
+		}
+		return result
+	}
+}
+
+func queryTable(t *testing.T, ds *PrestoDatasource, query map[string]interface{}) *data.Frame {
+	t.Helper()
+	query["format"] = "table"
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{Queries: []backend.DataQuery{dataQuery(t, "A", query)}})
+	if err != nil {
+		t.Fatalf("QueryData() error = %v", err)
+	}
+	res := resp.Responses["A"]
+	if res.Error != nil {
+		t.Fatalf("query error = %v", res.Error)
+	}
+	if len(res.Frames) != 1 {
+		t.Fatalf("query returned %d frames, want 1", len(res.Frames))
+	}
+	return res.Frames[0]
+}
+
+func frameNotices(frame *data.Frame) []string {
+	var notices []string
+	if frame.Meta != nil {
+		for _, notice := range frame.Meta.Notices {
+			notices = append(notices, notice.Text)
+		}
+	}
+	return notices
+}
+
+func TestQueryDataRowLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		rowLimit int
+		total    int
+		rows     int
+		sql      string
+		notice   string
+	}{
+		{name: "exactly the datasource limit", settings: map[string]interface{}{"RowLimit": 5}, total: 5, rows: 5, sql: "SELECT n, s FROM t"},
+		{name: "over the datasource limit", settings: map[string]interface{}{"RowLimit": 5}, total: 6, rows: 5, sql: "SELECT n, s FROM t",
+			notice: "Results have been limited to 5 rows by the row limit of the datasource, the query returned more rows"},
+		{name: "exactly the result row limit", settings: map[string]interface{}{"ResultRowLimit": 3}, total: 3, rows: 3, sql: "SELECT n, s FROM t LIMIT 4"},
+		{name: "over the result row limit", settings: map[string]interface{}{"ResultRowLimit": 3}, total: 10, rows: 3, sql: "SELECT n, s FROM t LIMIT 4",
+			notice: "Results have been limited to 3 rows by the result row limit of the datasource, the query returned more rows"},
+		{name: "over the query limit", settings: map[string]interface{}{"RowLimit": 5}, rowLimit: 2, total: 10, rows: 2, sql: "SELECT n, s FROM t LIMIT 3",
+			notice: "Results have been limited to 2 rows by the row limit of the query, the query returned more rows"},
+		{name: "query limit lower than the result row limit", settings: map[string]interface{}{"ResultRowLimit": 3}, rowLimit: 2, total: 2, rows: 2, sql: "SELECT n, s FROM t LIMIT 3"},
+		{name: "query limit can't raise the datasource limit", settings: map[string]interface{}{"RowLimit": 5}, rowLimit: 100, total: 10, rows: 5, sql: "SELECT n, s FROM t",
+			notice: "Results have been limited to 5 rows by the row limit of the datasource, the query returned more rows"},
+		{name: "query limit can't raise the result row limit", settings: map[string]interface{}{"ResultRowLimit": 3}, rowLimit: 100, total: 10, rows: 3, sql: "SELECT n, s FROM t LIMIT 4",
+			notice: "Results have been limited to 3 rows by the result row limit of the datasource, the query returned more rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presto := newFakePresto(t, numbersResult(tt.total))
+			ds := newTestDatasource(t, presto, tt.settings)
+			query := map[string]interface{}{"rawSql": "SELECT n, s FROM t"}
+			if tt.rowLimit > 0 {
+				query["rowLimit"] = tt.rowLimit
+			}
+			frame := queryTable(t, ds, query)
+			if rows, _ := frame.RowLen(); rows != tt.rows {
+				t.Errorf("query returned %d rows, want %d", rows, tt.rows)
+			}
+			if got := presto.received(); len(got) != 1 || got[0] != tt.sql {
+				t.Errorf("Presto received %q, want %q", got, tt.sql)
+			}
+			notices := frameNotices(frame)
+			if tt.notice == "" && len(notices) > 0 {
+				t.Errorf("query has the notices %q, want none", notices)
+			} else if tt.notice != "" && (len(notices) != 1 || notices[0] != tt.notice) {
+				t.Errorf("query has the notices %q, want %q", notices, tt.notice)
+			}
+		})
+	}
+}
+
+func TestQueryDataResultMaxBytes(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, numbersResult(100)), map[string]interface{}{"ResultMaxBytes": 2000})
+	frame := queryTable(t, ds, map[string]interface{}{"rawSql": "SELECT n, s FROM t"})
+	rows, _ := frame.RowLen()
+	if rows == 0 || rows >= 100 {
+		t.Fatalf("query returned %d rows, want the result cut off by its size", rows)
+	}
+	want := "Results have been limited to " + strconv.Itoa(rows) + " rows because the result exceeded the memory limit of 2000 bytes"
+	if notices := frameNotices(frame); len(notices) != 1 || notices[0] != want {
+		t.Errorf("query has the notices %q, want %q", notices, want)
+	}
+
+	t.Run("below the limit", func(t *testing.T) {
+		ds := newTestDatasource(t, newFakePresto(t, numbersResult(3)), map[string]interface{}{"ResultMaxBytes": 2000})
+		frame := queryTable(t, ds, map[string]interface{}{"rawSql": "SELECT n, s FROM t"})
+		if notices := frameNotices(frame); len(notices) > 0 {
+			t.Errorf("query below the memory limit has the notices %q", notices)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// queryGroup deduplicates concurrent executions of identical queries, so that viewers of
+// the same dashboard share one Presto query across requests and refIds.
+type queryGroup struct {
+	mu    sync.Mutex
+	calls map[string]*queryCall
+}
+
+type queryCall struct {
+	done    chan struct{}
+	frames  data.Frames
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newQueryGroup() *queryGroup {
+	return &queryGroup{calls: map[string]*queryCall{}}
+}
+
+// do runs fn once for all concurrent callers with the same key and returns a clone of its frames
+// to every caller. fn runs until it completes or every caller has given up waiting, shared reports
+// whether the frames were produced for another caller.
+func (g *queryGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (data.Frames, error)) (frames data.Frames, shared bool, err error) {
+	g.mu.Lock()
+	call, shared := g.calls[key]
+	if shared {
+		call.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(context.Background())
+		call = &queryCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = call
+		go g.run(callCtx, key, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, shared, call.err
+		}
+		return cloneFrames(call.frames), shared, nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if call.waiters--; call.waiters == 0 {
+			call.cancel()
+			g.forget(key, call)
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+func (g *queryGroup) run(ctx context.Context, key string, call *queryCall, fn func(ctx context.Context) (data.Frames, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			backend.Logger.Error("executeQuery panic", "error", r)
+			call.err = fmt.Errorf("%v", r)
+		}
+		g.mu.Lock()
+		g.forget(key, call)
+		g.mu.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+	call.frames, call.err = fn(ctx)
+}
+
+// forget removes call from the group, so that later callers start a new execution. The caller must hold mu.
+func (g *queryGroup) forget(key string, call *queryCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+func cloneFrames(frames data.Frames) data.Frames {
+	cloned := make(data.Frames, len(frames))
+	for i, frame := range frames {
+		cloned[i] = cloneFrame(frame)
+	}
+	return cloned
+}
+
+func cloneFrame(frame *data.Frame) *data.Frame {
+	cloned := frame.EmptyCopy()
+	if frame.Meta != nil {
+		meta := *frame.Meta
+		meta.Notices = append([]data.Notice(nil), frame.Meta.Notices...)
+		cloned.Meta = &meta
+	}
+	for i, field := range frame.Fields {
+		clonedField := cloned.Fields[i]
+		clonedField.Config = field.Config
+		clonedField.Extend(field.Len())
+		for j := 0; j < field.Len(); j++ {
+			clonedField.Set(j, field.CopyAt(j))
+		}
+	}
+	return cloned
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// waitFor waits until cond is true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (g *queryGroup) waiters(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if call, ok := g.calls[key]; ok {
+		return call.waiters
+	}
+	return 0
+}
+
+// blockingQuery returns a query function which counts its runs and returns a frame once release is closed.
+func blockingQuery(runs *int32, release chan struct{}) func(ctx context.Context) (data.Frames, error) {
+	return func(ctx context.Context) (data.Frames, error) {
+		atomic.AddInt32(runs, 1)
+		select {
+		case <-release:
+			return data.Frames{data.NewFrame("A", data.NewField("v", nil, []int64{1, 2}))}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestQueryGroupRunsOnce(t *testing.T) {
+	g := newQueryGroup()
+	var runs int32
+	release := make(chan struct{})
+	const callers = 5
+
+	type result struct {
+		frames data.Frames
+		shared bool
+		err    error
+	}
+	results := make(chan result, callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			frames, shared, err := g.do(context.Background(), "q", blockingQuery(&runs, release))
+			results <- result{frames, shared, err}
+		}()
+	}
+	waitFor(t, "all callers", func() bool { return g.waiters("q") == callers })
+	close(release)
+
+	var frames []*data.Frame
+	unshared := 0
+	for i := 0; i < callers; i++ {
+		r := <-results
+		if r.err != nil || len(r.frames) != 1 {
+			t.Fatalf("do() = %v, %v", r.frames, r.err)
+		}
+		if !r.shared {
+			unshared++
+		}
+		frames = append(frames, r.frames[0])
+	}
+	if runs != 1 {
+		t.Errorf("query ran %d times, want once", runs)
+	}
+	if unshared != 1 {
+		t.Errorf("%d callers ran the query, want 1", unshared)
+	}
+	// every caller gets its own copy of the frames
+	frames[0].Fields[0].Set(0, int64(42))
+	frames[0].Name = "changed"
+	for i, frame := range frames[1:] {
+		if frame == frames[0] || frame.Fields[0] == frames[0].Fields[0] {
+			t.Fatalf("caller %d shares its frame with caller 0", i+1)
+		}
+		if frame.Name != "A" || frame.Fields[0].At(0).(int64) != 1 {
+			t.Errorf("caller %d sees the change of the frame of caller 0", i+1)
+		}
+	}
+	if g.waiters("q") != 0 {
+		t.Error("finished query is still in the group")
+	}
+}
+
+func TestQueryGroupCallerCancels(t *testing.T) {
+	g := newQueryGroup()
+	var runs int32
+	release := make(chan struct{})
+	var queryCtx context.Context
+	var mu sync.Mutex
+	fn := func(ctx context.Context) (data.Frames, error) {
+		mu.Lock()
+		queryCtx = ctx
+		mu.Unlock()
+		return blockingQuery(&runs, release)(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(ctx, "q", fn)
+		first <- err
+	}()
+	second := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(context.Background(), "q", fn)
+		second <- err
+	}()
+	waitFor(t, "both callers", func() bool { return g.waiters("q") == 2 })
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("do() of the cancelled caller error = %v, want context.Canceled", err)
+	}
+	mu.Lock()
+	if queryCtx.Err() != nil {
+		t.Error("the query was cancelled while a caller was still waiting")
+	}
+	mu.Unlock()
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("do() of the remaining caller error = %v", err)
+	}
+}
+
+func TestQueryGroupAllCallersLeave(t *testing.T) {
+	g := newQueryGroup()
+	var runs int32
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (data.Frames, error) {
+		atomic.AddInt32(&runs, 1)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		ctx := ctx
+		go func() {
+			_, _, err := g.do(ctx, "q", fn)
+			errs <- err
+		}()
+	}
+	waitFor(t, "both callers", func() bool { return g.waiters("q") == 2 })
+	cancel1()
+	<-errs
+	select {
+	case <-cancelled:
+		t.Fatal("the query was cancelled before the last caller left")
+	case <-time.After(20 * time.Millisecond):
+	}
+	cancel2()
+	<-errs
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the query was not cancelled after every caller left")
+	}
+	if runs != 1 {
+		t.Errorf("query ran %d times, want once", runs)
+	}
+}
+
+func TestQueryGroupLateCaller(t *testing.T) {
+	g := newQueryGroup()
+	// the first execution ignores its cancellation until it is released
+	releaseFirst := make(chan struct{})
+	first := func(ctx context.Context) (data.Frames, error) {
+		<-releaseFirst
+		return nil, errors.New("first execution")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() { _, _, _ = g.do(ctx, "q", first) }()
+	waitFor(t, "the first caller", func() bool { return g.waiters("q") == 1 })
+	g.mu.Lock()
+	firstCall := g.calls["q"]
+	g.mu.Unlock()
+	cancel()
+	waitFor(t, "the first caller to leave", func() bool { return g.waiters("q") == 0 })
+
+	// a late caller starts a new execution, which the end of the first one must not forget
+	var secondRuns int32
+	releaseSecond := make(chan struct{})
+	second := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := g.do(context.Background(), "q", blockingQuery(&secondRuns, releaseSecond))
+			second <- err
+		}()
+		if i == 0 {
+			waitFor(t, "the late caller", func() bool { return g.waiters("q") == 1 })
+			close(releaseFirst)
+			<-firstCall.done
+		}
+	}
+	waitFor(t, "the callers of the second execution", func() bool { return g.waiters("q") == 2 })
+	close(releaseSecond)
+	for i := 0; i < 2; i++ {
+		if err := <-second; err != nil {
+			t.Errorf("do() of the second execution error = %v", err)
+		}
+	}
+	if secondRuns != 1 {
+		t.Errorf("second execution ran %d times, want once", secondRuns)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	macroPrefix      = "$__"
+	prestoTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// macroMaxArgs is the number of arguments each macro takes at most.
+var macroMaxArgs = map[string]int{
+	"interval":        0,
+	"interval_ms":     0,
+	"timeFilter":      1,
+	"timeFrom":        0,
+	"timeTo":          0,
+	"unixEpochFilter": 1,
+	"timeGroup":       3,
+}
+
+// macroEngine expands Grafana style macros such as $__timeFilter(col) into Presto SQL.
+// A $__timeGroup macro with a fill argument records the fill settings on the engine,
+// so they can be applied to the query model after the query has been executed.
+type macroEngine struct {
+	timeRange    backend.TimeRange
+	interval     time.Duration
+	fillMissing  *data.FillMissing
+	fillInterval time.Duration
+}
+
+func newMacroEngine(query backend.DataQuery) *macroEngine {
+	interval := query.Interval
+	if interval <= 0 && query.MaxDataPoints > 0 {
+		interval = query.TimeRange.Duration() / time.Duration(query.MaxDataPoints)
+	}
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return &macroEngine{
+		timeRange: backend.TimeRange{
+			From: query.TimeRange.From.UTC(),
+			To:   query.TimeRange.To.UTC(),
+		},
+		interval: interval,
+	}
+}
+
+// Interpolate replaces all macros in sql. Unknown macros are reported as errors
+// instead of being sent to Presto, macros in string literals and comments are kept.
+func (m *macroEngine) Interpolate(sql string) (string, error) {
+	tokens, err := tokenizeSQL(sql)
+	if err != nil {
+		return "", fmt.Errorf("invalid query: %w", err)
+	}
+	var sb strings.Builder
+	pos := 0
+	for {
+		idx := strings.Index(sql[pos:], macroPrefix)
+		if idx == -1 {
+			sb.WriteString(sql[pos:])
+			break
+		}
+		idx += pos
+		sb.WriteString(sql[pos:idx])
+		if end := quotedEnd(tokens, idx); end != -1 {
+			sb.WriteString(sql[idx:end])
+			pos = end
+			continue
+		}
+
+		nameEnd := idx + len(macroPrefix)
+		for nameEnd < len(sql) && isIdentifierChar(sql[nameEnd]) {
+			nameEnd++
+		}
+		name := sql[idx+len(macroPrefix) : nameEnd]
+
+		var args []string
+		hasArgs := nameEnd < len(sql) && sql[nameEnd] == '('
+		end := nameEnd
+		if hasArgs {
+			argsEnd, err := matchParenthesis(sql, nameEnd)
+			if err != nil {
+				return "", fmt.Errorf("macro %s%s: %w", macroPrefix, name, err)
+			}
+			args = splitMacroArgs(sql[nameEnd+1 : argsEnd])
+			end = argsEnd + 1
+		}
+
+		res, err := m.evaluate(name, hasArgs, args)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(res)
+		pos = end
+	}
+	return sb.String(), nil
+}
+
+// quotedEnd returns the end of the string literal, quoted identifier or comment of tokens
+// containing pos, or -1 if pos is outside of them.
+func quotedEnd(tokens []sqlToken, pos int) int {
+	for _, token := range tokens {
+		if token.pos > pos {
+			break
+		}
+		end := token.pos + len(token.text)
+		if pos < end && (token.kind == tokenString || token.kind == tokenQuotedIdentifier || token.kind == tokenComment) {
+			return end
+		}
+	}
+	return -1
+}
+
+func (m *macroEngine) evaluate(name string, hasArgs bool, args []string) (string, error) {
+	if limit, ok := macroMaxArgs[name]; ok && len(args) > limit {
+		return "", fmt.Errorf("too many arguments for macro %s%s, got %d, it takes at most %d", macroPrefix, name, len(args), limit)
+	}
+
+	switch name {
+	case "interval":
+		return formatInterval(m.interval), nil
+	case "interval_ms":
+		return strconv.FormatInt(m.interval.Milliseconds(), 10), nil
+	}
+	if !hasArgs {
+		return "", fmt.Errorf("macro %s%s requires an argument list", macroPrefix, name)
+	}
+
+	switch name {
+	case "timeFilter":
+		if len(args) != 1 || args[0] == "" {
+			return "", fmt.Errorf("missing time column argument for macro %s%s", macroPrefix, name)
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", args[0], prestoTimestamp(m.timeRange.From), prestoTimestamp(m.timeRange.To)), nil
+	case "timeFrom":
+		return prestoTimestamp(m.timeRange.From), nil
+	case "timeTo":
+		return prestoTimestamp(m.timeRange.To), nil
+	case "unixEpochFilter":
+		if len(args) != 1 || args[0] == "" {
+			return "", fmt.Errorf("missing time column argument for macro %s%s", macroPrefix, name)
+		}
+		return fmt.Sprintf("%s >= %d AND %s <= %d", args[0], m.timeRange.From.Unix(), args[0], m.timeRange.To.Unix()), nil
+	case "timeGroup":
+		if len(args) < 2 {
+			return "", fmt.Errorf("macro %s%s needs time column and interval", macroPrefix, name)
+		}
+		interval, err := gtime.ParseInterval(strings.Trim(args[1], `'"`))
+		if err != nil {
+			return "", fmt.Errorf("error parsing interval %v", args[1])
+		}
+		if interval < time.Second {
+			return "", fmt.Errorf("interval %v of macro %s%s must be at least 1s", args[1], macroPrefix, name)
+		}
+		if len(args) == 3 {
+			if err := m.setFill(args[2], interval); err != nil {
+				return "", err
+			}
+		}
+		seconds := int64(interval / time.Second)
+		return fmt.Sprintf("from_unixtime(floor(to_unixtime(%s) / %d) * %d)", args[0], seconds, seconds), nil
+	default:
+		return "", fmt.Errorf("unknown macro %s%s", macroPrefix, name)
+	}
+}
+
+func (m *macroEngine) setFill(mode string, interval time.Duration) error {
+	m.fillMissing = &data.FillMissing{}
+	m.fillInterval = interval
+	switch strings.ToLower(mode) {
+	case "null":
+		m.fillMissing.Mode = data.FillModeNull
+	case "previous":
+		m.fillMissing.Mode = data.FillModePrevious
+	default:
+		value, err := strconv.ParseFloat(mode, 64)
+		if err != nil {
+			return fmt.Errorf("error parsing fill value %v", mode)
+		}
+		m.fillMissing.Mode = data.FillModeValue
+		m.fillMissing.Value = value
+	}
+	return nil
+}
+
+func prestoTimestamp(t time.Time) string {
+	return fmt.Sprintf("from_iso8601_timestamp('%s')", t.UTC().Format(prestoTimeFormat))
+}
+
+// formatInterval formats d the same way Grafana renders $__interval on the frontend.
+func formatInterval(d time.Duration) string {
+	day := 24 * time.Hour
+	switch {
+	case d >= day && d%day == 0:
+		return fmt.Sprintf("%dd", d/day)
+	case d >= time.Hour && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d >= time.Minute && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d >= time.Second && d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return fmt.Sprintf("%dms", int64(math.Max(1, float64(d.Milliseconds()))))
+	}
+}
+
+// quoteIdentifier quotes name as a Presto identifier.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// quoteLiteral quotes s as a Presto string literal.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// matchParenthesis returns the index of the parenthesis closing the one at open,
+// skipping parentheses inside quoted strings and identifiers.
+func matchParenthesis(s string, open int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := open; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return -1, fmt.Errorf("missing closing parenthesis")
+}
+
+// splitMacroArgs splits macro arguments on top level commas.
+func splitMacroArgs(s string) []string {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	var args []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"':
+			quote = c
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func testMacroEngine() *macroEngine {
+	return newMacroEngine(backend.DataQuery{
+		TimeRange: backend.TimeRange{
+			From: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:   time.Date(2022, 1, 1, 1, 0, 0, 0, time.UTC),
+		},
+		Interval: 30 * time.Second,
+	})
+}
+
+func TestInterpolateMacros(t *testing.T) {
+	const (
+		from = "from_iso8601_timestamp('2022-01-01T00:00:00.000Z')"
+		to   = "from_iso8601_timestamp('2022-01-01T01:00:00.000Z')"
+	)
+	tests := []struct {
+		name string
+		sql  string
+		want string
+		err  string
+	}{
+		{name: "time filter", sql: "WHERE $__timeFilter(ts)", want: "WHERE ts BETWEEN " + from + " AND " + to},
+		{name: "time from and to", sql: "SELECT $__timeFrom(), $__timeTo()", want: "SELECT " + from + ", " + to},
+		{name: "epoch filter", sql: "WHERE $__unixEpochFilter(t)", want: "WHERE t >= 1640995200 AND t <= 1640998800"},
+		{name: "interval", sql: "SELECT '$__interval', $__interval_ms", want: "SELECT '$__interval', 30000"},
+		{name: "interval without quotes", sql: "SELECT $__interval", want: "SELECT 30s"},
+		{name: "time group", sql: "GROUP BY $__timeGroup(ts, '5m')", want: "GROUP BY from_unixtime(floor(to_unixtime(ts) / 300) * 300)"},
+		{name: "time group with fill", sql: "SELECT $__timeGroup(ts,'5m',previous)", want: "SELECT from_unixtime(floor(to_unixtime(ts) / 300) * 300)"},
+		{name: "nested arguments", sql: "WHERE $__timeFilter(date_trunc('hour', ts))", want: "WHERE date_trunc('hour', ts) BETWEEN " + from + " AND " + to},
+		{name: "string literal", sql: "SELECT '$__notamacro' AS x", want: "SELECT '$__notamacro' AS x"},
+		{name: "escaped string literal", sql: "SELECT 'it''s $__timeFilter(' AS x, $__interval_ms", want: "SELECT 'it''s $__timeFilter(' AS x, 30000"},
+		{name: "quoted identifier", sql: `SELECT 1 AS "$__timeFrom"`, want: `SELECT 1 AS "$__timeFrom"`},
+		{name: "line comment", sql: "SELECT 1 -- $__timeFilter(\nFROM t", want: "SELECT 1 -- $__timeFilter(\nFROM t"},
+		{name: "block comment", sql: "SELECT /* $__unknown */ $__timeTo()", want: "SELECT /* $__unknown */ " + to},
+		{name: "unknown macro", sql: "SELECT $__unknown(x)", err: "unknown macro $__unknown"},
+		{name: "missing argument list", sql: "WHERE $__timeFilter", err: "requires an argument list"},
+		{name: "missing argument", sql: "WHERE $__timeFilter()", err: "missing time column"},
+		{name: "missing parenthesis", sql: "WHERE $__timeFilter(ts", err: "missing closing parenthesis"},
+		{name: "extra time group argument", sql: "SELECT $__timeGroup(ts,'5m',previous,extra)", err: "too many arguments for macro $__timeGroup, got 4"},
+		{name: "extra time filter argument", sql: "WHERE $__timeFilter(ts, other)", err: "too many arguments for macro $__timeFilter, got 2"},
+		{name: "extra time from argument", sql: "SELECT $__timeFrom(ts)", err: "too many arguments for macro $__timeFrom, got 1"},
+		{name: "extra interval argument", sql: "SELECT $__interval(1)", err: "too many arguments for macro $__interval, got 1"},
+		{name: "short time group interval", sql: "SELECT $__timeGroup(ts, '500ms')", err: "must be at least 1s"},
+		{name: "invalid fill", sql: "SELECT $__timeGroup(ts, '1m', sometimes)", err: "error parsing fill value"},
+		{name: "unterminated literal", sql: "SELECT '$__timeFrom()", err: "invalid query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := testMacroEngine().Interpolate(tt.sql)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Interpolate(%q) error = %v, want %q", tt.sql, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Interpolate(%q) error = %v", tt.sql, err)
+			}
+			if got != tt.want {
+				t.Errorf("Interpolate(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateMacrosFill(t *testing.T) {
+	tests := []struct {
+		fill string
+		want data.FillMissing
+	}{
+		{fill: "NULL", want: data.FillMissing{Mode: data.FillModeNull}},
+		{fill: "previous", want: data.FillMissing{Mode: data.FillModePrevious}},
+		{fill: "1.5", want: data.FillMissing{Mode: data.FillModeValue, Value: 1.5}},
+	}
+	for _, tt := range tests {
+		m := testMacroEngine()
+		if _, err := m.Interpolate("SELECT $__timeGroup(ts, '2m', " + tt.fill + ")"); err != nil {
+			t.Fatalf("Interpolate() error = %v", err)
+		}
+		if m.fillMissing == nil || *m.fillMissing != tt.want || m.fillInterval != 2*time.Minute {
+			t.Errorf("fill %s = %+v every %v, want %+v every 2m", tt.fill, m.fillMissing, m.fillInterval, tt.want)
+		}
+	}
+}
+
+func TestFormatInterval(t *testing.T) {
+	tests := map[time.Duration]string{
+		48 * time.Hour:          "2d",
+		90 * time.Minute:        "90m",
+		2 * time.Hour:           "2h",
+		30 * time.Second:        "30s",
+		1500 * time.Millisecond: "1500ms",
+		time.Microsecond:        "1ms",
+	}
+	for d, want := range tests {
+		if got := formatInterval(d); got != want {
+			t.Errorf("formatInterval(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -45,6 +45,41 @@ var (
 		Help:      "num of update query error",
 		Name:      "query_error",
 	})
+
+	queryCacheHit = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "presto",
+		Subsystem: "plugin",
+		Help:      "num of query results served from cache",
+		Name:      "query_cache_hit",
+	}, []string{"datasource"})
+
+	queryCacheMiss = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "presto",
+		Subsystem: "plugin",
+		Help:      "num of cacheable queries not found in cache",
+		Name:      "query_cache_miss",
+	}, []string{"datasource"})
+
+	queryCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "presto",
+		Subsystem: "plugin",
+		Help:      "num of queries which shared the execution of an identical concurrent query",
+		Name:      "query_coalesced",
+	}, []string{"datasource"})
+
+	queriesQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "presto",
+		Subsystem: "plugin",
+		Help:      "num of queries waiting for a query slot",
+		Name:      "queries_queued",
+	}, []string{"datasource"})
+
+	queriesRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "presto",
+		Subsystem: "plugin",
+		Help:      "num of queries running on presto",
+		Name:      "queries_running",
+	}, []string{"datasource"})
 )
 
 func init() {
@@ -55,5 +90,10 @@ func init() {
 		queryPrestoCost,
 		tokenUpdateError,
 		queryError,
+		queryCacheHit,
+		queryCacheMiss,
+		queryCoalesced,
+		queriesQueued,
+		queriesRunning,
 	)
 }
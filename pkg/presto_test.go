@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// prestoColumn is a result column as sent by Presto.
+type prestoColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// prestoResult is the result of a statement of the fake Presto server. Rows are sent in pages of
+// pageSize rows, all in one page if pageSize is 0. Until hold is closed, if it is set, the pages
+// after the first are answered without rows, like Presto does while the query is still running.
+type prestoResult struct {
+	columns  []prestoColumn
+	rows     [][]interface{}
+	pageSize int
+	err      string
+	hold     <-chan struct{}
+}
+
+// fakePresto is a Presto server answering statements with the results of its handler.
+type fakePresto struct {
+	*httptest.Server
+	handler func(sql string) prestoResult
+
+	mu         sync.Mutex
+	results    map[string]prestoResult
+	requests   []*http.Request
+	statements []string
+}
+
+func newFakePresto(t *testing.T, handler func(sql string) prestoResult) *fakePresto {
+	p := &fakePresto{handler: handler, results: map[string]prestoResult{}}
+	p.Server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	t.Cleanup(p.Close)
+	return p
+}
+
+// received returns the SQL of the statements the server received.
+func (p *fakePresto) received() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.statements...)
+}
+
+func (p *fakePresto) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch req.Method {
+	case http.MethodPost:
+		body, _ := io.ReadAll(req.Body)
+		result := p.handler(string(body))
+		p.mu.Lock()
+		p.requests = append(p.requests, req)
+		p.statements = append(p.statements, string(body))
+		id := fmt.Sprintf("query%d", len(p.requests))
+		p.results[id] = result
+		p.mu.Unlock()
+		if result.err != "" {
+			writeJSON(w, map[string]interface{}{
+				"id":    id,
+				"error": map[string]interface{}{"message": result.err, "errorName": "SYNTAX_ERROR"},
+			})
+			return
+		}
+		writeJSON(w, map[string]interface{}{"id": id, "nextUri": p.pageURI(id, 0)})
+	case http.MethodGet:
+		p.mu.Lock()
+		p.requests = append(p.requests, req)
+		parts := strings.Split(req.URL.Path, "/")
+		id := parts[len(parts)-2]
+		page, _ := strconv.Atoi(parts[len(parts)-1])
+		result := p.results[id]
+		p.mu.Unlock()
+		if page > 0 && result.hold != nil {
+			select {
+			case <-result.hold:
+			case <-time.After(10 * time.Millisecond):
+				writeJSON(w, map[string]interface{}{"id": id, "nextUri": p.pageURI(id, page)})
+				return
+			}
+		}
+		rows, next := result.rows, ""
+		if result.pageSize > 0 {
+			start := page * result.pageSize
+			end := start + result.pageSize
+			if end < len(rows) {
+				next = p.pageURI(id, page+1)
+			} else {
+				end = len(rows)
+			}
+			rows = rows[start:end]
+		}
+		response := map[string]interface{}{"id": id, "columns": result.columns, "data": rows}
+		if next != "" {
+			response["nextUri"] = next
+		}
+		writeJSON(w, response)
+	default:
+		p.mu.Lock()
+		p.requests = append(p.requests, req)
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// requestsOf returns the paths of the requests of method the server received.
+func (p *fakePresto) requestsOf(method string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var paths []string
+	for _, req := range p.requests {
+		if req.Method == method {
+			paths = append(paths, req.URL.Path)
+		}
+	}
+	return paths
+}
+
+// statementHeaders returns the headers of the statements the server received.
+func (p *fakePresto) statementHeaders() []http.Header {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var headers []http.Header
+	for _, req := range p.requests {
+		if req.Method == http.MethodPost {
+			headers = append(headers, req.Header)
+		}
+	}
+	return headers
+}
+
+func (p *fakePresto) pageURI(id string, page int) string {
+	return fmt.Sprintf("%s/v1/statement/executing/%s/%d", p.URL, id, page)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+// newTestDatasource returns a datasource querying the fake Presto server with the settings of jsonData.
+func newTestDatasource(t *testing.T, presto *fakePresto, jsonData map[string]interface{}) *PrestoDatasource {
+	u, err := url.Parse(presto.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := map[string]interface{}{"HTTPScheme": "http", "Host": u.Host, "Catalog": "hive", "Schema": "default"}
+	for name, value := range jsonData {
+		settings[name] = value
+	}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance, err := NewDatasourceInstance(backend.DataSourceInstanceSettings{
+		Name:     strings.ReplaceAll(t.Name(), "/", "_"),
+		JSONData: b,
+	})
+	if err != nil {
+		t.Fatalf("NewDatasourceInstance() error = %v", err)
+	}
+	ds := instance.(*PrestoDatasource)
+	t.Cleanup(ds.Dispose)
+	return ds
+}
+
+// dataQuery returns a query with the JSON of query.
+func dataQuery(t *testing.T, refID string, query map[string]interface{}) backend.DataQuery {
+	query["refId"] = refID
+	b, err := json.Marshal(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backend.DataQuery{RefID: refID, JSON: b}
+}
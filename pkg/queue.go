@@ -0,0 +1,81 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// queryQueue limits the number of queries a datasource runs concurrently on Presto.
+// Queries beyond the limit wait in first come, first served order.
+type queryQueue struct {
+	name  string
+	limit int
+
+	mu      sync.Mutex
+	running int
+	waiting *list.List
+}
+
+func newQueryQueue(name string, limit int) *queryQueue {
+	return &queryQueue{name: name, limit: limit, waiting: list.New()}
+}
+
+// acquire waits until the query may run, for at most timeout. Every successful acquire must be followed by release.
+func (q *queryQueue) acquire(ctx context.Context, timeout time.Duration) error {
+	q.mu.Lock()
+	if q.limit <= 0 || (q.running < q.limit && q.waiting.Len() == 0) {
+		q.running++
+		q.mu.Unlock()
+		queriesRunning.WithLabelValues(q.name).Inc()
+		return nil
+	}
+	ready := make(chan struct{})
+	elem := q.waiting.PushBack(ready)
+	q.mu.Unlock()
+
+	queriesQueued.WithLabelValues(q.name).Inc()
+	defer queriesQueued.WithLabelValues(q.name).Dec()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ready:
+		queriesRunning.WithLabelValues(q.name).Inc()
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = fmt.Errorf("query waited longer than %v for one of the %d query slots of the datasource", timeout, q.limit)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-ready:
+		// the slot was handed over while giving up, pass it on
+		q.handOver()
+	default:
+		q.waiting.Remove(elem)
+	}
+	return err
+}
+
+func (q *queryQueue) release() {
+	queriesRunning.WithLabelValues(q.name).Dec()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handOver()
+}
+
+// handOver passes a free slot to the longest waiting query, the caller must hold mu.
+func (q *queryQueue) handOver() {
+	if front := q.waiting.Front(); front != nil {
+		close(q.waiting.Remove(front).(chan struct{}))
+		return
+	}
+	q.running--
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func (q *queryQueue) waitingLen() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.waiting.Len()
+}
+
+func checkQueueGauges(t *testing.T, q *queryQueue, queued, running float64) {
+	t.Helper()
+	if got := testutil.ToFloat64(queriesQueued.WithLabelValues(q.name)); got != queued {
+		t.Errorf("queries_queued = %v, want %v", got, queued)
+	}
+	if got := testutil.ToFloat64(queriesRunning.WithLabelValues(q.name)); got != running {
+		t.Errorf("queries_running = %v, want %v", got, running)
+	}
+}
+
+func TestQueryQueueOrder(t *testing.T) {
+	q := newQueryQueue(t.Name(), 1)
+	if err := q.acquire(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	const waiters = 4
+	for i := 0; i < waiters; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := q.acquire(context.Background(), 5*time.Second); err != nil {
+				t.Errorf("acquire() of waiter %d error = %v", i, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			q.release()
+		}()
+		waitFor(t, "the waiter to queue", func() bool { return q.waitingLen() == i+1 })
+	}
+	checkQueueGauges(t, q, waiters, 1)
+
+	q.release()
+	wg.Wait()
+	for i, waiter := range order {
+		if waiter != i {
+			t.Fatalf("queries ran in order %v, want first come, first served", order)
+		}
+	}
+	if len(order) != waiters {
+		t.Fatalf("%d of %d waiters ran", len(order), waiters)
+	}
+	checkQueueGauges(t, q, 0, 0)
+	if q.running != 0 {
+		t.Errorf("%d queries are running after all released their slot", q.running)
+	}
+}
+
+func TestQueryQueueTimeout(t *testing.T) {
+	q := newQueryQueue(t.Name(), 1)
+	if err := q.acquire(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	err := q.acquire(context.Background(), 20*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "query waited longer than 20ms for one of the 1 query slots") {
+		t.Fatalf("acquire() error = %v, want a queue timeout", err)
+	}
+	if q.waitingLen() != 0 {
+		t.Error("the timed out query is still waiting")
+	}
+	checkQueueGauges(t, q, 0, 1)
+
+	q.release()
+	checkQueueGauges(t, q, 0, 0)
+	if err := q.acquire(context.Background(), time.Second); err != nil {
+		t.Errorf("acquire() of the free slot error = %v", err)
+	}
+	q.release()
+}
+
+func TestQueryQueueCancel(t *testing.T) {
+	q := newQueryQueue(t.Name(), 1)
+	if err := q.acquire(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- q.acquire(ctx, 5*time.Second) }()
+	waitFor(t, "the query to queue", func() bool { return q.waitingLen() == 1 })
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire() error = %v, want context.Canceled", err)
+	}
+	if q.waitingLen() != 0 {
+		t.Error("the cancelled query is still waiting")
+	}
+	q.release()
+	checkQueueGauges(t, q, 0, 0)
+}
+
+// TestQueryQueueHandOverWhileGivingUp hands the slot to a waiter which has given up already,
+// the slot must be passed on to the next waiter.
+func TestQueryQueueHandOverWhileGivingUp(t *testing.T) {
+	q := newQueryQueue(t.Name(), 1)
+	if err := q.acquire(context.Background(), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() { first <- q.acquire(ctx, 5*time.Second) }()
+	waitFor(t, "the first waiter", func() bool { return q.waitingLen() == 1 })
+	second := make(chan error, 1)
+	go func() { second <- q.acquire(context.Background(), 5*time.Second) }()
+	waitFor(t, "the second waiter", func() bool { return q.waitingLen() == 2 })
+
+	// the first waiter gives up and blocks on mu, while the slot is released to it
+	q.mu.Lock()
+	cancel()
+	time.Sleep(20 * time.Millisecond)
+	queriesRunning.WithLabelValues(q.name).Dec()
+	q.handOver()
+	q.mu.Unlock()
+
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Fatalf("acquire() of the first waiter error = %v, want context.Canceled", err)
+	}
+	select {
+	case err := <-second:
+		if err != nil {
+			t.Fatalf("acquire() of the second waiter error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slot was not passed on to the second waiter")
+	}
+	checkQueueGauges(t, q, 0, 1)
+	q.release()
+	checkQueueGauges(t, q, 0, 0)
+	if q.running != 0 || q.waitingLen() != 0 {
+		t.Errorf("queue has %d running and %d waiting queries, want none", q.running, q.waitingLen())
+	}
+}
+
+func TestQueryQueueUnlimited(t *testing.T) {
+	q := newQueryQueue(t.Name(), 0)
+	for i := 0; i < 3; i++ {
+		if err := q.acquire(context.Background(), time.Millisecond); err != nil {
+			t.Fatalf("acquire() %d error = %v", i, err)
+		}
+	}
+	checkQueueGauges(t, q, 0, 3)
+	for i := 0; i < 3; i++ {
+		q.release()
+	}
+	checkQueueGauges(t, q, 0, 0)
+}
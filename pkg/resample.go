@@ -2,17 +2,107 @@ package main
 
 import (
 	"fmt"
+	"math"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
+// resampleAggregation defines how the values of the rows within a resampled interval
+// are combined into the value of the interval.
+type resampleAggregation string
+
+const (
+	resampleAggregationLast  resampleAggregation = "last"
+	resampleAggregationFirst resampleAggregation = "first"
+	resampleAggregationAvg   resampleAggregation = "avg"
+	resampleAggregationSum   resampleAggregation = "sum"
+	resampleAggregationMin   resampleAggregation = "min"
+	resampleAggregationMax   resampleAggregation = "max"
+	resampleAggregationCount resampleAggregation = "count"
+)
+
+func parseResampleAggregation(s string) (resampleAggregation, error) {
+	switch agg := resampleAggregation(strings.ToLower(s)); agg {
+	case "":
+		return resampleAggregationLast, nil
+	case resampleAggregationLast, resampleAggregationFirst, resampleAggregationAvg, resampleAggregationSum,
+		resampleAggregationMin, resampleAggregationMax, resampleAggregationCount:
+		return agg, nil
+	default:
+		return "", fmt.Errorf("unknown fill aggregation %q", s)
+	}
+}
+
+// aggregateRows combines the values of field at rows, which must not be empty.
+// Null and NaN values are ignored; if no value is left the result is null,
+// except for count which is zero then.
+func aggregateRows(field *data.Field, rows []int, aggregation resampleAggregation) interface{} {
+	last := field.At(rows[len(rows)-1])
+	switch aggregation {
+	case resampleAggregationFirst:
+		return field.At(rows[0])
+	case resampleAggregationAvg, resampleAggregationSum, resampleAggregationMin, resampleAggregationMax, resampleAggregationCount:
+	default:
+		return last
+	}
+
+	// series values are converted to float64 before resampling, other fields keep the last value
+	if t := field.Type(); t != data.FieldTypeFloat64 && t != data.FieldTypeNullableFloat64 {
+		return last
+	}
+
+	values := make([]float64, 0, len(rows))
+	for _, idx := range rows {
+		v, err := field.NullableFloatAt(idx)
+		if err != nil {
+			return last
+		}
+		if v == nil || math.IsNaN(*v) {
+			continue
+		}
+		values = append(values, *v)
+	}
+
+	var result float64
+	switch {
+	case aggregation == resampleAggregationCount:
+		result = float64(len(values))
+	case len(values) == 0:
+		if field.Nullable() {
+			return (*float64)(nil)
+		}
+		return math.NaN()
+	default:
+		result = values[0]
+		for _, v := range values[1:] {
+			switch aggregation {
+			case resampleAggregationMin:
+				result = math.Min(result, v)
+			case resampleAggregationMax:
+				result = math.Max(result, v)
+			default:
+				result += v
+			}
+		}
+		if aggregation == resampleAggregationAvg {
+			result /= float64(len(values))
+		}
+	}
+
+	if field.Nullable() {
+		return &result
+	}
+	return result
+}
+
 // getRowFillValues populates a slice of values corresponding to the provided data.Frame fields.
 // Uses data.FillMissing settings to fill in values that are missing. Values are normally missing
 // due to that the selected query interval doesn't match the intervals of the data returned from
 // the query and therefore needs to be resampled.
 func getRowFillValues(f *data.Frame, tsSchema data.TimeSeriesSchema, currentTime time.Time,
-	fillMissing *data.FillMissing, intermediateRows []int, lastSeenRowIdx int) []interface{} {
+	fillMissing *data.FillMissing, aggregation resampleAggregation, intermediateRows []int, lastSeenRowIdx int) []interface{} {
 	vals := make([]interface{}, 0, len(f.Fields))
 	for i, field := range f.Fields {
 		// if the current field is the time index of the series
@@ -36,7 +126,7 @@ func getRowFillValues(f *data.Frame, tsSchema data.TimeSeriesSchema, currentTime
 		}
 
 		// if the current field is value Field
-		// set the new value to the last seen field value (if such exists)
+		// set the new value to the aggregation of the intermediate values (if such exist)
 		// otherwise set the appropriate value according to the fillMissing mode
 		// if the current field is string field)
 		// set the new value to be added to the last seen value (if such exists)
@@ -44,10 +134,7 @@ func getRowFillValues(f *data.Frame, tsSchema data.TimeSeriesSchema, currentTime
 		var newVal interface{}
 		if isValueField {
 			if len(intermediateRows) > 0 {
-				// instead of setting the last seen
-				// we could set avg, sum, min or max
-				// of the intermediate values for each field
-				newVal = f.At(i, intermediateRows[len(intermediateRows)-1])
+				newVal = aggregateRows(field, intermediateRows, aggregation)
 			} else {
 				val, err := data.GetMissing(fillMissing, field, lastSeenRowIdx)
 				if err == nil {
@@ -72,9 +159,11 @@ func resample(f *data.Frame, qm dataQueryModel) (*data.Frame, error) {
 		return f, fmt.Errorf("can not fill missing, not timeseries frame")
 	}
 
-	if qm.Interval == 0 {
+	if qm.Interval <= 0 {
 		return f, nil
 	}
+	// rows are matched to the intervals in order of time
+	f = sortFrameByTime(f, tsSchema.TimeIndex)
 
 	newFields := make([]*data.Field, 0, len(f.Fields))
 	for _, field := range f.Fields {
@@ -90,12 +179,12 @@ func resample(f *data.Frame, qm dataQueryModel) (*data.Frame, error) {
 	lastSeenRowIdx := -1
 	timeField := f.Fields[tsSchema.TimeIndex]
 
-	startUnixTime := qm.TimeRange.From.Unix() / int64(qm.Interval.Seconds()) * int64(qm.Interval.Seconds())
-	startTime := time.Unix(startUnixTime, 0)
+	startUnixNano := qm.TimeRange.From.UnixNano() / int64(qm.Interval) * int64(qm.Interval)
+	startTime := time.Unix(0, startUnixNano).UTC()
 
 	for currentTime := startTime; !currentTime.After(qm.TimeRange.To); currentTime = currentTime.Add(qm.Interval) {
 		initialRowIdx := 0
-		if lastSeenRowIdx > 0 {
+		if lastSeenRowIdx >= 0 {
 			initialRowIdx = lastSeenRowIdx + 1
 		}
 		intermediateRows := make([]int, 0)
@@ -128,7 +217,7 @@ func resample(f *data.Frame, qm dataQueryModel) (*data.Frame, error) {
 		}
 
 		// no intermediate points; set values following fill missing mode
-		fieldVals := getRowFillValues(f, tsSchema, currentTime, qm.FillMissing, intermediateRows, lastSeenRowIdx)
+		fieldVals := getRowFillValues(f, tsSchema, currentTime, qm.FillMissing, qm.Aggregation, intermediateRows, lastSeenRowIdx)
 
 		resampledFrame.InsertRow(resampledRowidx, fieldVals...)
 		resampledRowidx++
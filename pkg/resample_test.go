@@ -0,0 +1,199 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestResample(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes float64) time.Time {
+		return start.Add(time.Duration(minutes * float64(time.Minute)))
+	}
+	v := func(f float64) *float64 { return &f }
+
+	tests := []struct {
+		name        string
+		times       []time.Time
+		values      []*float64
+		fill        data.FillMissing
+		aggregation resampleAggregation
+		interval    time.Duration
+		wantTimes   []time.Time
+		want        []*float64
+	}{
+		{
+			name:      "null fill",
+			times:     []time.Time{at(1), at(4)},
+			values:    []*float64{v(1), v(4)},
+			fill:      data.FillMissing{Mode: data.FillModeNull},
+			interval:  time.Minute,
+			wantTimes: []time.Time{at(0), at(1), at(2), at(3), at(4), at(5)},
+			want:      []*float64{nil, v(1), nil, nil, v(4), nil},
+		},
+		{
+			name:      "previous fill",
+			times:     []time.Time{at(1), at(4)},
+			values:    []*float64{v(1), v(4)},
+			fill:      data.FillMissing{Mode: data.FillModePrevious},
+			interval:  time.Minute,
+			wantTimes: []time.Time{at(0), at(1), at(2), at(3), at(4), at(5)},
+			want:      []*float64{nil, v(1), v(1), v(1), v(4), v(4)},
+		},
+		{
+			name:      "value fill",
+			times:     []time.Time{at(1), at(4)},
+			values:    []*float64{v(1), v(4)},
+			fill:      data.FillMissing{Mode: data.FillModeValue, Value: -1},
+			interval:  time.Minute,
+			wantTimes: []time.Time{at(0), at(1), at(2), at(3), at(4), at(5)},
+			want:      []*float64{v(-1), v(1), v(-1), v(-1), v(4), v(-1)},
+		},
+		{
+			name:      "sparse gaps keep the last value of an interval",
+			times:     []time.Time{at(0.5), at(0.75), at(3.5)},
+			values:    []*float64{v(1), v(2), v(3)},
+			fill:      data.FillMissing{Mode: data.FillModeNull},
+			interval:  2 * time.Minute,
+			wantTimes: []time.Time{at(0), at(2), at(4)},
+			want:      []*float64{nil, v(2), v(3)},
+		},
+		{
+			name:        "sparse gaps aggregated",
+			times:       []time.Time{at(0.5), at(0.75), at(3.5)},
+			values:      []*float64{v(1), v(2), v(3)},
+			fill:        data.FillMissing{Mode: data.FillModeValue, Value: 0},
+			aggregation: resampleAggregationSum,
+			interval:    2 * time.Minute,
+			wantTimes:   []time.Time{at(0), at(2), at(4)},
+			want:        []*float64{v(0), v(3), v(3)},
+		},
+		{
+			name:        "null values are not aggregated",
+			times:       []time.Time{at(1), at(1.5), at(2)},
+			values:      []*float64{v(1), nil, v(3)},
+			fill:        data.FillMissing{Mode: data.FillModeNull},
+			aggregation: resampleAggregationAvg,
+			interval:    5 * time.Minute,
+			wantTimes:   []time.Time{at(0), at(5)},
+			want:        []*float64{nil, v(2)},
+		},
+		{
+			name:      "unsorted input",
+			times:     []time.Time{at(4), at(1), at(2)},
+			values:    []*float64{v(4), v(1), v(2)},
+			fill:      data.FillMissing{Mode: data.FillModePrevious},
+			interval:  time.Minute,
+			wantTimes: []time.Time{at(0), at(1), at(2), at(3), at(4), at(5)},
+			want:      []*float64{nil, v(1), v(2), v(2), v(4), v(4)},
+		},
+		{
+			name:      "zero interval keeps the frame",
+			times:     []time.Time{at(4), at(1)},
+			values:    []*float64{v(4), v(1)},
+			fill:      data.FillMissing{Mode: data.FillModeNull},
+			wantTimes: []time.Time{at(4), at(1)},
+			want:      []*float64{v(4), v(1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := data.NewFrame("",
+				data.NewField("time", nil, tt.times),
+				data.NewField("value", nil, tt.values),
+			)
+			aggregation := tt.aggregation
+			if aggregation == "" {
+				aggregation = resampleAggregationLast
+			}
+			fill := tt.fill
+			qm := dataQueryModel{
+				FillMissing: &fill,
+				Interval:    tt.interval,
+				Aggregation: aggregation,
+			}
+			qm.TimeRange.From = at(0)
+			qm.TimeRange.To = at(5)
+
+			resampled, err := resample(frame, qm)
+			if err != nil {
+				t.Fatalf("resample() error = %v", err)
+			}
+			if got := resampled.Rows(); got != len(tt.wantTimes) {
+				t.Fatalf("resample() returned %d rows, want %d", got, len(tt.wantTimes))
+			}
+			for i, want := range tt.wantTimes {
+				if got := resampled.Fields[0].At(i).(time.Time); !got.Equal(want) {
+					t.Errorf("time of row %d = %v, want %v", i, got, want)
+				}
+				got, err := resampled.Fields[1].NullableFloatAt(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if (got == nil) != (tt.want[i] == nil) || (got != nil && *got != *tt.want[i]) {
+					t.Errorf("value of row %d = %v, want %v", i, formatFloat(got), formatFloat(tt.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestResampleNotTimeSeries(t *testing.T) {
+	frame := data.NewFrame("", data.NewField("value", nil, []float64{1}))
+	if _, err := resample(frame, dataQueryModel{Interval: time.Minute}); err == nil {
+		t.Error("resample() of a frame without time field succeeded")
+	}
+}
+
+func TestSetFill(t *testing.T) {
+	tests := []struct {
+		name         string
+		query        Query
+		wantInterval time.Duration
+		wantFill     *data.FillMissing
+	}{
+		{name: "disabled", query: Query{FillInterval: 10}},
+		{name: "panel interval by default", query: Query{Fill: true}, wantInterval: 30 * time.Second, wantFill: &data.FillMissing{Mode: data.FillModeNull}},
+		{name: "fill interval", query: Query{Fill: true, FillInterval: 0.5, FillMode: "previous"}, wantInterval: 500 * time.Millisecond, wantFill: &data.FillMissing{Mode: data.FillModePrevious}},
+		{name: "fill value", query: Query{Fill: true, FillMode: "VALUE", FillValue: 2}, wantInterval: 30 * time.Second, wantFill: &data.FillMissing{Mode: data.FillModeValue, Value: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qm := &dataQueryModel{}
+			if err := qm.setFill(tt.query, 30*time.Second); err != nil {
+				t.Fatalf("setFill() error = %v", err)
+			}
+			if qm.Interval != tt.wantInterval {
+				t.Errorf("interval = %v, want %v", qm.Interval, tt.wantInterval)
+			}
+			if (qm.FillMissing == nil) != (tt.wantFill == nil) || (qm.FillMissing != nil && *qm.FillMissing != *tt.wantFill) {
+				t.Errorf("fill missing = %+v, want %+v", qm.FillMissing, tt.wantFill)
+			}
+		})
+	}
+}
+
+func TestSetFillAggregation(t *testing.T) {
+	qm := &dataQueryModel{}
+	if err := qm.setFill(Query{FillAggregation: "median"}, time.Minute); err != nil {
+		t.Errorf("setFill() without fill error = %v", err)
+	}
+	if err := qm.setFill(Query{Fill: true, FillAggregation: "median"}, time.Minute); err == nil {
+		t.Error("setFill() with unknown aggregation succeeded")
+	}
+	if err := qm.setFill(Query{Fill: true, FillAggregation: "AVG"}, time.Minute); err != nil || qm.Aggregation != resampleAggregationAvg {
+		t.Errorf("setFill() aggregation = %q, %v", qm.Aggregation, err)
+	}
+	if err := qm.setFill(Query{Fill: true}, time.Minute); err != nil || qm.Aggregation != resampleAggregationLast {
+		t.Errorf("setFill() default aggregation = %q, %v", qm.Aggregation, err)
+	}
+}
+
+func formatFloat(f *float64) interface{} {
+	if f == nil {
+		return nil
+	}
+	return *f
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+const (
+	schemaCacheTTL     = time.Minute
+	schemaCacheEntries = 1024
+)
+
+type columnInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// schemaCache caches the results of schema browsing queries for a short time,
+// so that autocompletion in the query editor doesn't flood Presto with metadata queries.
+type schemaCache struct {
+	mu      sync.Mutex
+	entries map[string]schemaCacheEntry
+}
+
+type schemaCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{entries: map[string]schemaCacheEntry{}}
+}
+
+func (c *schemaCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *schemaCache) set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries) >= schemaCacheEntries {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= schemaCacheEntries {
+		c.entries = map[string]schemaCacheEntry{}
+	}
+	c.entries[key] = schemaCacheEntry{value: value, expires: now.Add(schemaCacheTTL)}
+}
+
+func (ds *PrestoDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	return ds.resourceHandler.CallResource(ctx, req, sender)
+}
+
+func (ds *PrestoDatasource) newResourceHandler() backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/catalogs", ds.handleCatalogs)
+	mux.HandleFunc("/schemas", ds.handleSchemas)
+	mux.HandleFunc("/tables", ds.handleTables)
+	mux.HandleFunc("/columns", ds.handleColumns)
+	return httpadapter.New(mux)
+}
+
+func (ds *PrestoDatasource) handleCatalogs(rw http.ResponseWriter, req *http.Request) {
+	ds.serveNames(rw, req, "SELECT catalog_name FROM system.metadata.catalogs ORDER BY catalog_name")
+}
+
+func (ds *PrestoDatasource) handleSchemas(rw http.ResponseWriter, req *http.Request) {
+	catalog := ds.resourceParam(req, "catalog", ds.settings.PrestoParam.Catalog)
+	if catalog == "" {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("missing catalog"))
+		return
+	}
+	ds.serveNames(rw, req, fmt.Sprintf("SELECT schema_name FROM %s.information_schema.schemata ORDER BY schema_name",
+		quoteIdentifier(catalog)))
+}
+
+func (ds *PrestoDatasource) handleTables(rw http.ResponseWriter, req *http.Request) {
+	catalog := ds.resourceParam(req, "catalog", ds.settings.PrestoParam.Catalog)
+	schema := ds.resourceParam(req, "schema", ds.settings.PrestoParam.Schema)
+	if catalog == "" || schema == "" {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("missing catalog or schema"))
+		return
+	}
+	ds.serveNames(rw, req, fmt.Sprintf("SELECT table_name FROM %s.information_schema.tables WHERE table_schema = %s ORDER BY table_name",
+		quoteIdentifier(catalog), quoteLiteral(schema)))
+}
+
+func (ds *PrestoDatasource) handleColumns(rw http.ResponseWriter, req *http.Request) {
+	catalog := ds.resourceParam(req, "catalog", ds.settings.PrestoParam.Catalog)
+	schema := ds.resourceParam(req, "schema", ds.settings.PrestoParam.Schema)
+	table := req.URL.Query().Get("table")
+	// the table may be qualified as schema.table or catalog.schema.table
+	if parts := strings.Split(table, "."); len(parts) == 2 {
+		schema, table = parts[0], parts[1]
+	} else if len(parts) == 3 {
+		catalog, schema, table = parts[0], parts[1], parts[2]
+	}
+	if catalog == "" || schema == "" || table == "" {
+		writeResourceError(rw, http.StatusBadRequest, fmt.Errorf("missing catalog, schema or table"))
+		return
+	}
+	query := fmt.Sprintf("SELECT column_name, data_type FROM %s.information_schema.columns WHERE table_schema = %s AND table_name = %s ORDER BY ordinal_position",
+		quoteIdentifier(catalog), quoteLiteral(schema), quoteLiteral(table))
+
+	ds.serveResource(rw, req, query, func(ctx context.Context, opts connOptions) (interface{}, error) {
+		columns := []columnInfo{}
+		err := ds.scanResource(ctx, opts, query, func(values ...*string) {
+			columns = append(columns, columnInfo{Name: *values[0], Type: *values[1]})
+		}, 2)
+		return columns, err
+	})
+}
+
+func (ds *PrestoDatasource) serveNames(rw http.ResponseWriter, req *http.Request, query string) {
+	ds.serveResource(rw, req, query, func(ctx context.Context, opts connOptions) (interface{}, error) {
+		names := []string{}
+		err := ds.scanResource(ctx, opts, query, func(values ...*string) {
+			names = append(names, *values[0])
+		}, 1)
+		return names, err
+	})
+}
+
+// serveResource writes the result of load as JSON, results are cached per query and connection options.
+func (ds *PrestoDatasource) serveResource(rw http.ResponseWriter, req *http.Request, query string,
+	load func(ctx context.Context, opts connOptions) (interface{}, error)) {
+	opts, err := ds.connOptionsFor(httpadapter.UserFromContext(req.Context()))
+	if err != nil {
+		writeResourceError(rw, http.StatusForbidden, err)
+		return
+	}
+
+	key := opts.key() + "\n" + query
+	result, ok := ds.schemaCache.get(key)
+	if !ok {
+		ctx, cancel := context.WithTimeout(req.Context(), ds.queryTimeout())
+		defer cancel()
+		if result, err = load(ctx, opts); err != nil {
+			writeResourceError(rw, http.StatusInternalServerError, ds.contextError(ctx, err))
+			return
+		}
+		ds.schemaCache.set(key, result)
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(result); err != nil {
+		backend.Logger.Warn("Failed to write resource response.", "err", err.Error())
+	}
+}
+
+// scanResource runs query and calls fn with the first n string columns of each row,
+// reading no more rows than the row limits allow.
+func (ds *PrestoDatasource) scanResource(ctx context.Context, opts connOptions, query string, fn func(values ...*string), n int) error {
+	if err := ds.queue.acquire(ctx, ds.queueTimeout()); err != nil {
+		return err
+	}
+	defer ds.queue.release()
+
+	limit := ds.rowLimit(0)
+	rows, release, err := ds.queryRows(ctx, opts, query, limit)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer rows.Close()
+
+	values := make([]*string, n)
+	dest := make([]interface{}, n)
+	for i := range values {
+		values[i] = new(string)
+		dest[i] = values[i]
+	}
+	for i := int64(0); i < limit.rows && rows.Next(); i++ {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		fn(values...)
+	}
+	return rows.Err()
+}
+
+func (ds *PrestoDatasource) resourceParam(req *http.Request, name string, defaultValue string) string {
+	if value := req.URL.Query().Get(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func writeResourceError(rw http.ResponseWriter, status int, err error) {
+	backend.Logger.Error(fmt.Sprintf("Resource error: %v", err))
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	rw.Write(body)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// schemaResult answers the schema browsing queries with two rows of names or columns.
+func schemaResult(sql string) prestoResult {
+	if strings.Contains(sql, "information_schema.columns") {
+		return prestoResult{
+			columns: []prestoColumn{{"column_name", "varchar"}, {"data_type", "varchar"}},
+			rows:    [][]interface{}{{"time", "timestamp"}, {"value", "double"}},
+		}
+	}
+	return prestoResult{
+		columns: []prestoColumn{{"name", "varchar"}},
+		rows:    [][]interface{}{{"a"}, {"b"}},
+	}
+}
+
+type resourceResponse struct {
+	*backend.CallResourceResponse
+}
+
+func (r *resourceResponse) Send(resp *backend.CallResourceResponse) error {
+	r.CallResourceResponse = resp
+	return nil
+}
+
+// callResource requests the resource at path as user.
+func callResource(t *testing.T, ds *PrestoDatasource, user string, path string, params url.Values) *backend.CallResourceResponse {
+	t.Helper()
+	req := &backend.CallResourceRequest{
+		Path:   path,
+		Method: http.MethodGet,
+		URL:    path + "?" + params.Encode(),
+	}
+	if user != "" {
+		req.PluginContext.User = &backend.User{Login: user}
+	}
+	var resp resourceResponse
+	if err := ds.CallResource(context.Background(), req, &resp); err != nil {
+		t.Fatalf("CallResource(%s) error = %v", path, err)
+	}
+	if resp.CallResourceResponse == nil {
+		t.Fatalf("CallResource(%s) sent no response", path)
+	}
+	return resp.CallResourceResponse
+}
+
+func TestResources(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		params url.Values
+		sql    string
+		body   string
+	}{
+		{
+			name: "catalogs",
+			path: "catalogs",
+			sql:  "SELECT catalog_name FROM system.metadata.catalogs ORDER BY catalog_name",
+			body: `["a","b"]`,
+		},
+		{
+			name: "schemas of the datasource catalog",
+			path: "schemas",
+			sql:  `SELECT schema_name FROM "hive".information_schema.schemata ORDER BY schema_name`,
+			body: `["a","b"]`,
+		},
+		{
+			name:   "schemas",
+			path:   "schemas",
+			params: url.Values{"catalog": {`my"catalog`}},
+			sql:    `SELECT schema_name FROM "my""catalog".information_schema.schemata ORDER BY schema_name`,
+			body:   `["a","b"]`,
+		},
+		{
+			name: "tables of the datasource schema",
+			path: "tables",
+			sql:  `SELECT table_name FROM "hive".information_schema.tables WHERE table_schema = 'default' ORDER BY table_name`,
+			body: `["a","b"]`,
+		},
+		{
+			name:   "tables",
+			path:   "tables",
+			params: url.Values{"catalog": {"iceberg"}, "schema": {"o'brien"}},
+			sql:    `SELECT table_name FROM "iceberg".information_schema.tables WHERE table_schema = 'o''brien' ORDER BY table_name`,
+			body:   `["a","b"]`,
+		},
+		{
+			name:   "columns",
+			path:   "columns",
+			params: url.Values{"table": {"events"}},
+			sql: `SELECT column_name, data_type FROM "hive".information_schema.columns WHERE table_schema = 'default' ` +
+				`AND table_name = 'events' ORDER BY ordinal_position`,
+			body: `[{"name":"time","type":"timestamp"},{"name":"value","type":"double"}]`,
+		},
+		{
+			name:   "columns of a qualified table",
+			path:   "columns",
+			params: url.Values{"table": {`my"catalog.web.page's`}},
+			sql: `SELECT column_name, data_type FROM "my""catalog".information_schema.columns WHERE table_schema = 'web' ` +
+				`AND table_name = 'page''s' ORDER BY ordinal_position`,
+			body: `[{"name":"time","type":"timestamp"},{"name":"value","type":"double"}]`,
+		},
+		{
+			name:   "columns of a table qualified with the schema",
+			path:   "columns",
+			params: url.Values{"catalog": {"iceberg"}, "table": {"web.pages"}},
+			sql: `SELECT column_name, data_type FROM "iceberg".information_schema.columns WHERE table_schema = 'web' ` +
+				`AND table_name = 'pages' ORDER BY ordinal_position`,
+			body: `[{"name":"time","type":"timestamp"},{"name":"value","type":"double"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presto := newFakePresto(t, schemaResult)
+			ds := newTestDatasource(t, presto, nil)
+			resp := callResource(t, ds, "", tt.path, tt.params)
+			if resp.Status != http.StatusOK {
+				t.Fatalf("/%s status = %d: %s", tt.path, resp.Status, resp.Body)
+			}
+			if got := strings.TrimSpace(string(resp.Body)); got != tt.body {
+				t.Errorf("/%s = %s, want %s", tt.path, got, tt.body)
+			}
+			if received := presto.received(); len(received) != 1 || received[0] != tt.sql {
+				t.Errorf("/%s queried %q, want %q", tt.path, received, tt.sql)
+			}
+		})
+	}
+}
+
+func TestResourcesMissingParams(t *testing.T) {
+	tests := []struct {
+		path   string
+		params url.Values
+	}{
+		{path: "schemas"},
+		{path: "tables"},
+		{path: "tables", params: url.Values{"catalog": {"hive"}}},
+		{path: "columns", params: url.Values{"catalog": {"hive"}, "schema": {"web"}}},
+		{path: "columns", params: url.Values{"schema": {"web"}, "table": {"pages"}}},
+	}
+	presto := newFakePresto(t, schemaResult)
+	ds := newTestDatasource(t, presto, map[string]interface{}{"Catalog": "", "Schema": ""})
+	for _, tt := range tests {
+		resp := callResource(t, ds, "", tt.path, tt.params)
+		if resp.Status != http.StatusBadRequest {
+			t.Errorf("/%s?%s status = %d, want %d", tt.path, tt.params.Encode(), resp.Status, http.StatusBadRequest)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(resp.Body, &body); err != nil || !strings.HasPrefix(body["error"], "missing") {
+			t.Errorf("/%s?%s body = %s, want a missing parameter error", tt.path, tt.params.Encode(), resp.Body)
+		}
+	}
+	if received := presto.received(); len(received) != 0 {
+		t.Errorf("requests with missing parameters queried %q", received)
+	}
+}
+
+func TestResourcesCachedPerUser(t *testing.T) {
+	presto := newFakePresto(t, schemaResult)
+	ds := newTestDatasource(t, presto, map[string]interface{}{"ForwardUser": true})
+	for _, user := range []string{"alice", "alice", "bob", "alice", "bob"} {
+		if resp := callResource(t, ds, user, "catalogs", nil); resp.Status != http.StatusOK {
+			t.Fatalf("/catalogs of %s status = %d: %s", user, resp.Status, resp.Body)
+		}
+	}
+	// other parameters are cached separately
+	if resp := callResource(t, ds, "alice", "schemas", nil); resp.Status != http.StatusOK {
+		t.Fatalf("/schemas status = %d: %s", resp.Status, resp.Body)
+	}
+
+	headers := presto.statementHeaders()
+	var users []string
+	for _, header := range headers {
+		users = append(users, header.Get("X-Presto-User"))
+	}
+	if got, want := strings.Join(users, ","), "alice,bob,alice"; got != want {
+		t.Errorf("resources were queried as %s, want %s", got, want)
+	}
+}
+
+func TestResourcesRowLimit(t *testing.T) {
+	presto := newFakePresto(t, schemaResult)
+	ds := newTestDatasource(t, presto, map[string]interface{}{"RowLimit": 1})
+	resp := callResource(t, ds, "", "catalogs", nil)
+	if got := strings.TrimSpace(string(resp.Body)); resp.Status != http.StatusOK || got != `["a"]` {
+		t.Errorf("/catalogs = %d %s, want the first name", resp.Status, got)
+	}
+}
+
+func TestResourcesRejectedUser(t *testing.T) {
+	presto := newFakePresto(t, schemaResult)
+	ds := newTestDatasource(t, presto, map[string]interface{}{"ForwardUser": true, "UserAllowlistOnly": true})
+	for _, user := range []string{"alice", ""} {
+		if resp := callResource(t, ds, user, "catalogs", nil); resp.Status != http.StatusForbidden {
+			t.Errorf("/catalogs of %q status = %d, want %d", user, resp.Status, http.StatusForbidden)
+		}
+	}
+	if received := presto.received(); len(received) != 0 {
+		t.Errorf("rejected requests queried %q", received)
+	}
+}
@@ -36,6 +36,7 @@ func newProcessCfg(query backend.DataQuery, queryContext context.Context,
 	}
 
 	qm := &dataQueryModel{
+		location:     time.UTC,
 		columnTypes:  columnTypes,
 		columnNames:  columnNames,
 		rows:         rows,
@@ -52,20 +53,15 @@ func newProcessCfg(query backend.DataQuery, queryContext context.Context,
 		return nil, err
 	}
 
-	/*if queryJson.Fill {
-		qm.FillMissing = &data.FillMissing{}
-		qm.Interval = time.Duration(queryJson.FillInterval * float64(time.Second))
-		switch strings.ToLower(queryJson.FillMode) {
-		case "null":
-			qm.FillMissing.Mode = data.FillModeNull
-		case "previous":
-			qm.FillMissing.Mode = data.FillModePrevious
-		case "value":
-			qm.FillMissing.Mode = data.FillModeValue
-			qm.FillMissing.Value = queryJson.FillValue
-		default:
-		}
-	}*/
+	qm.VariableSort = queryJson.VariableSort
+	qm.Explode = queryJson.Explode
+	qm.Decimals = queryJson.Decimals
+	if err := checkDecimalsMode(qm.Decimals); err != nil {
+		return nil, err
+	}
+	if err := qm.setFill(queryJson, query.Interval); err != nil {
+		return nil, err
+	}
 
 	qm.TimeRange.From = query.TimeRange.From.UTC()
 	qm.TimeRange.To = query.TimeRange.To.UTC()
@@ -75,19 +71,43 @@ func newProcessCfg(query backend.DataQuery, queryContext context.Context,
 		qm.Format = dataQueryFormatSeries
 	case "table":
 		qm.Format = dataQueryFormatTable
+	case "annotation":
+		qm.Format = dataQueryFormatAnnotation
+	case "variable":
+		qm.Format = dataQueryFormatVariable
+	case "":
 	default:
-		panic(fmt.Sprintf("Unrecognized query model format: %q", queryJson.Format))
+		return nil, fmt.Errorf("unrecognized query model format: %q", queryJson.Format)
+	}
+
+	// only series and annotations fall back to a column of time type, tables, variables and
+	// queries without format keep the shape of their result unless a column is named as time
+	var typeNames []string
+	if qm.Format == dataQueryFormatSeries || qm.Format == dataQueryFormatAnnotation {
+		for _, ct := range qm.columnTypes {
+			typeNames = append(typeNames, databaseTypeName(ct))
+		}
+	}
+	if qm.timeIndex, err = findTimeColumn(queryJson.TimeColumn, qm.columnNames, typeNames); err != nil {
+		return nil, err
+	}
+	if qm.timeLayout, err = timeLayout(queryJson.TimeFormat); err != nil {
+		return nil, err
+	}
+	if queryJson.Format == "" {
+		// queries of alert rules and provisioned dashboards may come without format
+		qm.Format = dataQueryFormatTable
+		if qm.timeIndex != -1 {
+			qm.Format = dataQueryFormatSeries
+		}
 	}
 
 	for i, col := range qm.columnNames {
-		for _, tc := range TimeColumnNames {
-			if col == tc {
-				qm.timeIndex = i
-				break
-			}
+		if i == qm.timeIndex {
+			continue
 		}
 
-		if qm.Format == dataQueryFormatTable && col == "timeend" {
+		if qm.Format != dataQueryFormatSeries && col == "timeend" {
 			qm.timeEndIndex = i
 			continue
 		}
@@ -97,7 +117,7 @@ func newProcessCfg(query backend.DataQuery, queryContext context.Context,
 			qm.metricIndex = i
 		default:
 			if qm.metricIndex == -1 {
-				columnType := qm.columnTypes[i].DatabaseTypeName()
+				columnType := databaseTypeName(qm.columnTypes[i])
 				for _, mct := range MetricColumnTypes {
 					if columnType == mct {
 						qm.metricIndex = i
@@ -111,6 +131,68 @@ func newProcessCfg(query backend.DataQuery, queryContext context.Context,
 	return qm, nil
 }
 
+// setFill sets up the resampling of a query with fill enabled. The interval defaults to the
+// interval of the panel.
+func (qm *dataQueryModel) setFill(queryJson Query, panelInterval time.Duration) error {
+	if !queryJson.Fill {
+		return nil
+	}
+	var err error
+	if qm.Aggregation, err = parseResampleAggregation(queryJson.FillAggregation); err != nil {
+		return err
+	}
+	qm.FillMissing = &data.FillMissing{}
+	qm.Interval = time.Duration(queryJson.FillInterval * float64(time.Second))
+	if qm.Interval <= 0 {
+		qm.Interval = panelInterval
+	}
+	switch strings.ToLower(queryJson.FillMode) {
+	case "previous":
+		qm.FillMissing.Mode = data.FillModePrevious
+	case "value":
+		qm.FillMissing.Mode = data.FillModeValue
+		qm.FillMissing.Value = queryJson.FillValue
+	default:
+		qm.FillMissing.Mode = data.FillModeNull
+	}
+	return nil
+}
+
+// findTimeColumn returns the index of the time column: the column named by timeColumn if set, else the
+// first column named like TimeColumnNames, else the first column of timestamp or date type of typeNames.
+func findTimeColumn(timeColumn string, columnNames []string, typeNames []string) (int, error) {
+	if timeColumn != "" {
+		for i, col := range columnNames {
+			if col == timeColumn {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("time column %q not found", timeColumn)
+	}
+	for i, col := range columnNames {
+		if isTimeColumnName(col) {
+			return i, nil
+		}
+	}
+	for i, typeName := range typeNames {
+		base, _ := splitType(typePrecisionRegex.ReplaceAllString(typeName, ""))
+		switch base {
+		case "timestamp", "timestamp with time zone", "date":
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func isTimeColumnName(name string) bool {
+	for _, tc := range TimeColumnNames {
+		if name == tc {
+			return true
+		}
+	}
+	return false
+}
+
 // dataQueryFormat is the type of query.
 type dataQueryFormat string
 
@@ -119,6 +201,10 @@ const (
 	dataQueryFormatTable dataQueryFormat = "table"
 	// dataQueryFormatSeries identifies a time series query.
 	dataQueryFormatSeries dataQueryFormat = "time_series"
+	// dataQueryFormatAnnotation identifies an annotation query.
+	dataQueryFormatAnnotation dataQueryFormat = "annotation"
+	// dataQueryFormatVariable identifies a template variable query.
+	dataQueryFormatVariable dataQueryFormat = "variable"
 )
 
 type dataQueryModel struct {
@@ -127,6 +213,14 @@ type dataQueryModel struct {
 	TimeRange    backend.TimeRange
 	FillMissing  *data.FillMissing // property not set until after Interpolate()
 	Interval     time.Duration
+	Aggregation  resampleAggregation
+	VariableSort int
+	Explode      bool
+	Decimals     string
+	// location is the time zone of timestamps without time zone.
+	location *time.Location
+	// timeLayout is the Go layout of string time columns, see parseTimeString.
+	timeLayout   string
 	columnNames  []string
 	columnTypes  []*sql.ColumnType
 	timeIndex    int
@@ -460,37 +554,45 @@ func convertNullableFloat32ToEpochMS(origin *data.Field, newField *data.Field) {
 	}
 }
 
-func convertStringToEpochMS(origin *data.Field, newField *data.Field) {
+func convertStringToEpochMS(origin *data.Field, newField *data.Field, layout string, loc *time.Location) error {
 	valueLength := origin.Len()
 	for i := 0; i < valueLength; i++ {
 		timeStr := origin.At(i).(string)
-		value, _ := time.Parse(time.RFC3339, timeStr)
+		value, err := parseTimeString(timeStr, layout, loc)
+		if err != nil {
+			return err
+		}
 		newField.Append(&value)
 	}
+	return nil
 }
 
-func convertNullableStringToEpochMS(origin *data.Field, newField *data.Field) {
+func convertNullableStringToEpochMS(origin *data.Field, newField *data.Field, layout string, loc *time.Location) error {
 	valueLength := origin.Len()
 	for i := 0; i < valueLength; i++ {
 		iv := origin.At(i).(*string)
 		if iv == nil {
 			newField.Append(nil)
 		} else {
-			value, _ := time.Parse(time.RFC3339, *iv)
+			value, err := parseTimeString(*iv, layout, loc)
+			if err != nil {
+				return err
+			}
 			newField.Append(&value)
 		}
 	}
+	return nil
 }
 
 func convertSQLTimeColumnsToEpochMS(frame *data.Frame, qm *dataQueryModel) error {
 	if qm.timeIndex != -1 {
-		if err := convertSQLTimeColumnToEpochMS(frame, qm.timeIndex); err != nil {
+		if err := convertSQLTimeColumnToEpochMS(frame, qm.timeIndex, qm.timeLayout, qm.location); err != nil {
 			return errors.Wrap(err, "failed to convert time column")
 		}
 	}
 
 	if qm.timeEndIndex != -1 {
-		if err := convertSQLTimeColumnToEpochMS(frame, qm.timeEndIndex); err != nil {
+		if err := convertSQLTimeColumnToEpochMS(frame, qm.timeEndIndex, qm.timeLayout, qm.location); err != nil {
 			return errors.Wrap(err, "failed to convert timeend column")
 		}
 	}
@@ -500,7 +602,7 @@ func convertSQLTimeColumnsToEpochMS(frame *data.Frame, qm *dataQueryModel) error
 
 // convertSQLTimeColumnToEpochMS converts column named time to unix timestamp in milliseconds
 // to make native datetime types and epoch dates work in annotation and table queries.
-func convertSQLTimeColumnToEpochMS(frame *data.Frame, timeIndex int) error {
+func convertSQLTimeColumnToEpochMS(frame *data.Frame, timeIndex int, layout string, loc *time.Location) error {
 	if timeIndex < 0 || timeIndex >= len(frame.Fields) {
 		return fmt.Errorf("timeIndex %d is out of range", timeIndex)
 	}
@@ -541,9 +643,13 @@ func convertSQLTimeColumnToEpochMS(frame *data.Frame, timeIndex int) error {
 	case data.FieldTypeNullableFloat32:
 		convertNullableFloat32ToEpochMS(frame.Fields[timeIndex], newField)
 	case data.FieldTypeString:
-		convertStringToEpochMS(frame.Fields[timeIndex], newField)
+		if err := convertStringToEpochMS(frame.Fields[timeIndex], newField, layout, loc); err != nil {
+			return err
+		}
 	case data.FieldTypeNullableString:
-		convertNullableStringToEpochMS(frame.Fields[timeIndex], newField)
+		if err := convertNullableStringToEpochMS(frame.Fields[timeIndex], newField, layout, loc); err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("column type %q is not convertible to time.Time", valueType)
 	}
@@ -617,13 +723,17 @@ func convertSQLValueColumnToFloat(frame *data.Frame, Index int) (*data.Frame, er
 }
 
 // epochPrecisionToMS converts epoch precision to millisecond, if needed.
-// Only seconds to milliseconds supported right now
+// Seconds, microseconds and nanoseconds are supported.
 func epochPrecisionToMS(value float64) float64 {
 	s := strconv.FormatFloat(value, 'e', -1, 64)
 	if strings.HasSuffix(s, "e+09") {
 		return value * float64(1e3)
 	}
 
+	if strings.HasSuffix(s, "e+15") {
+		return value / float64(time.Microsecond)
+	}
+
 	if strings.HasSuffix(s, "e+18") {
 		return value / float64(time.Millisecond)
 	}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	templateVariablePattern    = regexp.MustCompile(`\$\{(\w+)(?::(\w+))?\}`)
+	literalVariablePattern     = regexp.MustCompile(`^\$\{(\w+)\}$`)
+	plainVariableValuePattern  = regexp.MustCompile(`^[\w.\-:/]*$`)
+	rawVariableValuePattern    = regexp.MustCompile(`^[\w.\-:/ ]*$`)
+	numberVariableValuePattern = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][-+]?\d+)?$`)
+)
+
+// interpolateVariables replaces the references ${name} and ${name:format} of template variables,
+// whose values the frontend passes separately. Without format the values are inserted as they are,
+// they may only contain letters, digits and . - : / _, unless the reference is an item of an IN list,
+// e.g. IN (${name}) or IN ('a', ${name}), whose values are quoted as string literals. The formats are:
+//   - singlequote: 'a','b'
+//   - doublequote: "a","b", for identifiers
+//   - in: ('a','b'), an IN list which matches nothing if no value is selected
+//   - array: ARRAY['a','b']
+//   - number: 1,2, values must be numbers
+//   - raw, csv: a,b, values may only contain letters, digits and . - : / _ and spaces
+//
+// Inside of string literals the value is escaped instead, a literal like '${name}' becomes the
+// quoted values. References in comments and to unknown variables are left as they are. Other
+// formats, like Grafana's sqlstring or regex, are replaced by the frontend and never get here.
+func interpolateVariables(sql string, variables map[string][]string) (string, error) {
+	if len(variables) == 0 {
+		return sql, nil
+	}
+	tokens, err := tokenizeSQL(sql)
+	if err != nil {
+		return "", fmt.Errorf("invalid query: %w", err)
+	}
+	var sb strings.Builder
+	start := 0
+	for _, token := range tokens {
+		if token.kind != tokenString && token.kind != tokenComment {
+			continue
+		}
+		code, err := interpolateCode(sql[start:token.pos], start, tokens, variables)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(code)
+		if token.kind == tokenString {
+			literal, err := interpolateLiteral(token.text, variables)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(literal)
+		} else {
+			sb.WriteString(token.text)
+		}
+		start = token.pos + len(token.text)
+	}
+	code, err := interpolateCode(sql[start:], start, tokens, variables)
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(code)
+	return sb.String(), nil
+}
+
+// interpolateCode replaces the variable references of SQL text outside of string literals and comments.
+// sql starts at offset of the query, whose tokens tell IN lists apart.
+func interpolateCode(sql string, offset int, tokens []sqlToken, variables map[string][]string) (string, error) {
+	var sb strings.Builder
+	start := 0
+	for _, loc := range templateVariablePattern.FindAllStringSubmatchIndex(sql, -1) {
+		name, format := sql[loc[2]:loc[3]], ""
+		if loc[4] != -1 {
+			format = sql[loc[4]:loc[5]]
+		}
+		values, ok := variables[name]
+		if !ok {
+			continue
+		}
+		if format == "" && inListAt(tokens, offset+loc[0]) {
+			format = "singlequote"
+		}
+		rendered, err := renderVariable(name, format, values)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(sql[start:loc[0]])
+		sb.WriteString(rendered)
+		start = loc[1]
+	}
+	sb.WriteString(sql[start:])
+	return sb.String(), nil
+}
+
+// inListAt reports whether pos of the query is inside of the parentheses of an IN list,
+// like IN ('a', ${name}), but not of an IN subquery or a function call within the list.
+func inListAt(tokens []sqlToken, pos int) bool {
+	var open []int
+	for i, token := range tokens {
+		if token.pos >= pos {
+			break
+		}
+		if token.kind != tokenSymbol {
+			continue
+		}
+		switch token.text {
+		case "(":
+			open = append(open, i)
+		case ")":
+			if len(open) > 0 {
+				open = open[:len(open)-1]
+			}
+		}
+	}
+	if len(open) == 0 {
+		return false
+	}
+	paren := open[len(open)-1]
+	if prev := adjacentToken(tokens, paren, -1); prev == nil || prev.keyword() != "IN" {
+		return false
+	}
+	if next := adjacentToken(tokens, paren, 1); next != nil {
+		switch next.keyword() {
+		case "SELECT", "WITH", "VALUES", "TABLE":
+			return false
+		}
+	}
+	return true
+}
+
+// adjacentToken returns the token before (step -1) or after (step 1) the token at index i,
+// skipping comments, or nil if there is none.
+func adjacentToken(tokens []sqlToken, i int, step int) *sqlToken {
+	for i += step; i >= 0 && i < len(tokens); i += step {
+		if tokens[i].kind != tokenComment {
+			return &tokens[i]
+		}
+	}
+	return nil
+}
+
+// interpolateLiteral replaces the variable references inside of a string literal by their escaped value.
+// A literal which is just a reference, like '${name}', becomes the quoted values.
+func interpolateLiteral(literal string, variables map[string][]string) (string, error) {
+	content := literal[1 : len(literal)-1]
+	if m := literalVariablePattern.FindStringSubmatch(content); m != nil {
+		if values, ok := variables[m[1]]; ok && len(values) != 1 {
+			return renderVariable(m[1], "singlequote", values)
+		}
+	}
+	var err error
+	content = templateVariablePattern.ReplaceAllStringFunc(content, func(ref string) string {
+		match := templateVariablePattern.FindStringSubmatch(ref)
+		values, ok := variables[match[1]]
+		if !ok || err != nil {
+			return ref
+		}
+		switch {
+		case match[2] == "raw" || match[2] == "csv":
+			// the values as text, like the frontend inserts them
+			if err = checkMacroPrefix(match[1], values); err == nil {
+				return strings.ReplaceAll(strings.Join(values, ","), "'", "''")
+			}
+		case match[2] != "":
+			err = fmt.Errorf("variable %s must not have the format %s inside of a string literal", match[1], match[2])
+		case len(values) > 1:
+			err = fmt.Errorf("variable %s has multiple values, which can't be used inside of a string literal", match[1])
+		case len(values) == 1:
+			if err = checkMacroPrefix(match[1], values); err == nil {
+				return strings.ReplaceAll(values[0], "'", "''")
+			}
+		}
+		return ""
+	})
+	if err != nil {
+		return "", err
+	}
+	return "'" + content + "'", nil
+}
+
+// checkMacroPrefix rejects values containing a macro, the macros are expanded after the variables.
+func checkMacroPrefix(name string, values []string) error {
+	for _, value := range values {
+		if strings.Contains(value, macroPrefix) {
+			return fmt.Errorf("value %q of variable %s must not contain %s", value, name, macroPrefix)
+		}
+	}
+	return nil
+}
+
+func renderVariable(name, format string, values []string) (string, error) {
+	if err := checkMacroPrefix(name, values); err != nil {
+		return "", err
+	}
+
+	quoted := func(quote func(string) string) []string {
+		items := make([]string, len(values))
+		for i, value := range values {
+			items[i] = quote(value)
+		}
+		return items
+	}
+	validated := func(pattern *regexp.Regexp) ([]string, error) {
+		for _, value := range values {
+			if !pattern.MatchString(value) || strings.Contains(value, "--") {
+				if format == "" {
+					return nil, fmt.Errorf("value %q of variable %s is not allowed without quotes, use a format like ${%s:singlequote}", value, name, name)
+				}
+				return nil, fmt.Errorf("value %q of variable %s is not allowed in %s format", value, name, format)
+			}
+		}
+		return values, nil
+	}
+
+	switch format {
+	case "":
+		if len(values) == 0 {
+			return "", fmt.Errorf("variable %s has no value", name)
+		}
+		items, err := validated(plainVariableValuePattern)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(items, ","), nil
+	case "singlequote":
+		if len(values) == 0 {
+			return "NULL", nil
+		}
+		return strings.Join(quoted(quoteLiteral), ","), nil
+	case "doublequote":
+		if len(values) == 0 {
+			return "", fmt.Errorf("variable %s has no value", name)
+		}
+		return strings.Join(quoted(quoteIdentifier), ","), nil
+	case "in":
+		if len(values) == 0 {
+			return "(NULL)", nil
+		}
+		return "(" + strings.Join(quoted(quoteLiteral), ",") + ")", nil
+	case "array":
+		return "ARRAY[" + strings.Join(quoted(quoteLiteral), ",") + "]", nil
+	case "number":
+		items, err := validated(numberVariableValuePattern)
+		if err != nil {
+			return "", err
+		}
+		if len(items) == 0 {
+			return "NULL", nil
+		}
+		return strings.Join(items, ","), nil
+	case "raw", "csv":
+		items, err := validated(rawVariableValuePattern)
+		if err != nil {
+			return "", err
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unknown format %q of variable %s", format, name)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterpolateVariables(t *testing.T) {
+	variables := map[string][]string{
+		"host":   {"a"},
+		"hosts":  {"a", "b"},
+		"none":   {},
+		"n":      {"10"},
+		"table":  {"events"},
+		"quote":  {"o'brien"},
+		"spaced": {"new york"},
+		"column": {`my "col"`},
+		"nums":   {"1", "2.5"},
+		"dashes": {"1 --"},
+	}
+	tests := []struct {
+		name string
+		sql  string
+		want string
+		err  string
+	}{
+		{name: "raw by default", sql: "SELECT * FROM ${table} LIMIT ${n}", want: "SELECT * FROM events LIMIT 10"},
+		{name: "raw multiple values", sql: "SELECT ${hosts}", want: "SELECT a,b"},
+		{name: "raw rejects quotes", sql: "SELECT ${quote}", err: "not allowed without quotes"},
+		{name: "raw rejects spaces", sql: "SELECT ${spaced}", err: "not allowed without quotes"},
+		{name: "raw without value", sql: "SELECT ${none}", err: "has no value"},
+		{name: "in list", sql: "WHERE host IN (${hosts})", want: "WHERE host IN ('a','b')"},
+		{name: "in list single value", sql: "WHERE host in ( ${quote} )", want: "WHERE host in ( 'o''brien' )"},
+		{name: "in list without value", sql: "WHERE host IN (${none})", want: "WHERE host IN (NULL)"},
+		{name: "in list with other items", sql: "WHERE host IN ('x', ${hosts})", want: "WHERE host IN ('x', 'a','b')"},
+		{name: "in list with items after", sql: "WHERE host IN (${quote}, 'x', ${host})", want: "WHERE host IN ('o''brien', 'x', 'a')"},
+		{name: "in list with comments", sql: "WHERE host IN /* hosts */ (\n-- list\n'x', ${spaced})", want: "WHERE host IN /* hosts */ (\n-- list\n'x', 'new york')"},
+		{name: "in list with format", sql: "WHERE n IN (1, ${nums:number})", want: "WHERE n IN (1, 1,2.5)"},
+		{name: "in list subquery", sql: "WHERE host IN (SELECT host FROM ${table} LIMIT ${n})", want: "WHERE host IN (SELECT host FROM events LIMIT 10)"},
+		{name: "function in in list", sql: "WHERE host IN (lower(${host}), 'x')", want: "WHERE host IN (lower(a), 'x')"},
+		{name: "after in list", sql: "WHERE host IN ('x') AND n > ${n}", want: "WHERE host IN ('x') AND n > 10"},
+		{name: "not an in list", sql: "SELECT coalesce(${host}, 'x')", want: "SELECT coalesce(a, 'x')"},
+		{name: "legacy literal", sql: "WHERE host = '${host}'", want: "WHERE host = 'a'"},
+		{name: "legacy literal escaped", sql: "WHERE host = '${quote}'", want: "WHERE host = 'o''brien'"},
+		{name: "legacy literal with spaces", sql: "WHERE city = '${spaced}'", want: "WHERE city = 'new york'"},
+		{name: "legacy literal multiple values", sql: "WHERE host IN ('${hosts}')", want: "WHERE host IN ('a','b')"},
+		{name: "legacy literal without value", sql: "WHERE host = '${none}'", want: "WHERE host = NULL"},
+		{name: "inside of a literal", sql: "WHERE url LIKE 'http://${quote}/%'", want: "WHERE url LIKE 'http://o''brien/%'"},
+		{name: "multiple values inside of a literal", sql: "WHERE url LIKE '%${hosts}%'", err: "multiple values"},
+		{name: "format inside of a literal", sql: "WHERE host = '${host:singlequote}'", err: "must not have the format singlequote"},
+		{name: "raw inside of a literal", sql: "WHERE host = '${quote:raw}' OR host LIKE '%${hosts:csv}%'", want: "WHERE host = 'o''brien' OR host LIKE '%a,b%'"},
+		{name: "line comment", sql: "SELECT 1 -- ${quote}\nFROM ${table}", want: "SELECT 1 -- ${quote}\nFROM events"},
+		{name: "block comment", sql: "SELECT /* ${quote} */ 1", want: "SELECT /* ${quote} */ 1"},
+		{name: "unknown variable", sql: "SELECT ${unknown}", want: "SELECT ${unknown}"},
+		{name: "singlequote", sql: "SELECT ${hosts:singlequote}, ${none:singlequote}", want: "SELECT 'a','b', NULL"},
+		{name: "doublequote", sql: "SELECT ${column:doublequote} FROM t", want: `SELECT "my ""col""" FROM t`},
+		{name: "doublequote without value", sql: "SELECT ${none:doublequote}", err: "has no value"},
+		{name: "in", sql: "WHERE host IN ${hosts:in} OR host IN ${none:in}", want: "WHERE host IN ('a','b') OR host IN (NULL)"},
+		{name: "array", sql: "SELECT ${quote:array}, ${none:array}", want: "SELECT ARRAY['o''brien'], ARRAY[]"},
+		{name: "number", sql: "SELECT ${nums:number}, ${none:number}", want: "SELECT 1,2.5, NULL"},
+		{name: "number rejects text", sql: "SELECT ${host:number}", err: "not allowed in number format"},
+		{name: "raw", sql: "SELECT '${host}' AS h, ${spaced:raw}", want: "SELECT 'a' AS h, new york"},
+		{name: "csv", sql: "SELECT ${hosts:csv}", want: "SELECT a,b"},
+		{name: "raw rejects comments", sql: "SELECT ${dashes:raw}", err: "not allowed in raw format"},
+		{name: "unknown format", sql: "SELECT ${host:sql}", err: `unknown format "sql"`},
+		{name: "unterminated literal", sql: "SELECT '${host}", err: "invalid query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := interpolateVariables(tt.sql, variables)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("interpolateVariables(%q) error = %v, want %q", tt.sql, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("interpolateVariables(%q) error = %v", tt.sql, err)
+			}
+			if got != tt.want {
+				t.Errorf("interpolateVariables(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterpolateVariablesRejectsMacros(t *testing.T) {
+	variables := map[string][]string{"v": {"$__timeFrom()"}}
+	for _, sql := range []string{"SELECT ${v:singlequote}", "SELECT '${v}'", "SELECT 'x ${v}'"} {
+		if _, err := interpolateVariables(sql, variables); err == nil || !strings.Contains(err.Error(), macroPrefix) {
+			t.Errorf("interpolateVariables(%q) error = %v, want macro error", sql, err)
+		}
+	}
+}
+
+func TestInterpolateVariablesWithoutVariables(t *testing.T) {
+	sql := "SELECT '${host}"
+	got, err := interpolateVariables(sql, nil)
+	if err != nil || got != sql {
+		t.Errorf("interpolateVariables(%q, nil) = %q, %v", sql, got, err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// javaLayouts maps the letters of Java date time patterns, as used by Presto's parse_datetime, to Go layouts.
+// The layouts are indexed by the number of repetitions of a letter, the last one is used for more.
+var javaLayouts = map[byte][]string{
+	'y': {"2006", "06", "2006", "2006"},
+	'M': {"1", "01", "Jan", "January"},
+	'd': {"2", "02"},
+	'D': {"002"},
+	'H': {"15"},
+	'h': {"3", "03"},
+	'm': {"4", "04"},
+	's': {"5", "05"},
+	'a': {"PM"},
+	'E': {"Mon", "Mon", "Mon", "Monday"},
+	'Z': {"-0700", "-07:00"},
+	'X': {"Z07", "Z0700", "Z07:00"},
+	'z': {"MST"},
+}
+
+// mysqlLayouts maps the specifiers of MySQL formats, as used by Presto's date_parse, to Go layouts.
+var mysqlLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'c': "1",
+	'd': "02",
+	'e': "2",
+	'j': "002",
+	'H': "15",
+	'k': "15",
+	'h': "03",
+	'I': "03",
+	'l': "3",
+	'i': "04",
+	's': "05",
+	'S': "05",
+	'f': "000000",
+	'p': "PM",
+	'b': "Jan",
+	'M': "January",
+	'a': "Mon",
+	'W': "Monday",
+	'T': "15:04:05",
+	'r': "03:04:05 PM",
+	'%': "%",
+}
+
+// timeLayout returns the Go layout of a time format, which is either a Go layout like
+// 2006-01-02 15:04:05, a MySQL format like %Y-%m-%d %H:%i:%s or a Java pattern like
+// yyyy-MM-dd HH:mm:ss. Formats with a % are MySQL formats, formats with digits Go layouts.
+func timeLayout(format string) (string, error) {
+	switch {
+	case format == "":
+		return "", nil
+	case strings.Contains(format, "%"):
+		return mysqlLayout(format)
+	case strings.ContainsAny(format, "0123456789"):
+		return format, nil
+	default:
+		return javaLayout(format)
+	}
+}
+
+func mysqlLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout.WriteByte(format[i])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("time format %q ends with %%", format)
+		}
+		s, ok := mysqlLayouts[format[i]]
+		if !ok {
+			return "", fmt.Errorf("unsupported specifier %%%c of time format %q", format[i], format)
+		}
+		layout.WriteString(s)
+	}
+	return layout.String(), nil
+}
+
+func javaLayout(format string) (string, error) {
+	var layout strings.Builder
+	for i := 0; i < len(format); {
+		c := format[i]
+		n := 1
+		for i+n < len(format) && format[i+n] == c {
+			n++
+		}
+		switch {
+		case c == '\'':
+			// quoted text, two quotes are a quote
+			if n%2 == 0 {
+				layout.WriteString(strings.Repeat("'", n/2))
+				i += n
+				continue
+			}
+			end := strings.IndexByte(format[i+1:], '\'')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated quote in time format %q", format)
+			}
+			layout.WriteString(format[i+1 : i+1+end])
+			i += end + 2
+			continue
+		case c == 'S':
+			// fractions of a second follow a literal dot or comma
+			layout.WriteString(strings.Repeat("0", n))
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			layouts, ok := javaLayouts[c]
+			if !ok {
+				return "", fmt.Errorf("unsupported pattern letter %q of time format %q", c, format)
+			}
+			if n > len(layouts) {
+				layout.WriteString(layouts[len(layouts)-1])
+			} else {
+				layout.WriteString(layouts[n-1])
+			}
+		default:
+			layout.WriteString(format[i : i+n])
+		}
+		i += n
+	}
+	return layout.String(), nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestTimeLayout(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+		err    string
+	}{
+		{format: "", want: ""},
+		{format: "2006-01-02 15:04:05", want: "2006-01-02 15:04:05"},
+		{format: "%Y-%m-%d %H:%i:%s", want: "2006-01-02 15:04:05"},
+		{format: "%Y-%m-%d %H:%i:%s.%f", want: "2006-01-02 15:04:05.000000"},
+		{format: "%d/%b/%Y:%T", want: "02/Jan/2006:15:04:05"},
+		{format: "%Y-%m-%d %q", err: "unsupported specifier %q"},
+		{format: "%Y-%m-%d %", err: "ends with %"},
+		{format: "yyyy-MM-dd HH:mm:ss", want: "2006-01-02 15:04:05"},
+		{format: "yyyy-MM-dd'T'HH:mm:ss.SSSZZ", want: "2006-01-02T15:04:05.000-07:00"},
+		{format: "yy/M/d h:mm a", want: "06/1/2 3:04 PM"},
+		{format: "EEEE, MMMM dd yyyy", want: "Monday, January 02 2006"},
+		{format: "dd MMM yyyy HH:mm:ss z", want: "02 Jan 2006 15:04:05 MST"},
+		{format: "yyyyMMdd''HHmm", want: "20060102'1504"},
+		{format: "yyyy-MM-dd'T", err: "unterminated quote"},
+		{format: "yyyy-MM-dd G", err: "unsupported pattern letter 'G'"},
+	}
+	for _, tt := range tests {
+		got, err := timeLayout(tt.format)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("timeLayout(%q) = %q, %v, want error %q", tt.format, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("timeLayout(%q) = %q, %v, want %q", tt.format, got, err, tt.want)
+		}
+	}
+}
+
+func TestJavaLayoutParse(t *testing.T) {
+	layout, err := javaLayout("yyyy-MM-dd HH:mm:ss.SSS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := time.Parse(layout, "2022-03-04 05:06:07.089")
+	if want := time.Date(2022, 3, 4, 5, 6, 7, 89e6, time.UTC); err != nil || !got.Equal(want) {
+		t.Errorf("time.Parse(%q) = %v, %v, want %v", layout, got, err, want)
+	}
+}
+
+func TestFindTimeColumn(t *testing.T) {
+	names := []string{"created", "host", "time_sec", "day"}
+	types := []string{"timestamp(3) with time zone", "varchar", "bigint", "date"}
+	tests := []struct {
+		name       string
+		timeColumn string
+		names      []string
+		types      []string
+		want       int
+		err        string
+	}{
+		{name: "time column setting first", timeColumn: "day", names: names, types: types, want: 3},
+		{name: "time column setting of a column without time type", timeColumn: "host", names: names, types: types, want: 1},
+		{name: "missing time column", timeColumn: "ts", names: names, types: types, err: `time column "ts" not found`},
+		{name: "name before type", names: names, types: types, want: 2},
+		{name: "first name", names: []string{"time_sec", "time"}, types: []string{"bigint", "bigint"}, want: 0},
+		{name: "type", names: []string{"host", "created", "day"}, types: []string{"varchar", "timestamp(3)", "date"}, want: 1},
+		{name: "type with time zone", names: []string{"host", "created"}, types: []string{"varchar", "timestamp(6) with time zone"}, want: 1},
+		{name: "date type", names: []string{"host", "day"}, types: []string{"varchar", "date"}, want: 1},
+		{name: "time type is no timestamp", names: []string{"t"}, types: []string{"time"}, want: -1},
+		{name: "no type detection", names: []string{"host", "created"}, want: -1},
+		{name: "name without type detection", names: []string{"host", "time"}, want: 1},
+	}
+	for _, tt := range tests {
+		got, err := findTimeColumn(tt.timeColumn, tt.names, tt.types)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: findTimeColumn() error = %v, want %q", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: findTimeColumn() = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// createdResult is a result with a timestamp column which isn't named as time column.
+func createdResult(string) prestoResult {
+	return prestoResult{
+		columns: []prestoColumn{{"created", "timestamp(3)"}, {"host", "varchar"}, {"value", "bigint"}},
+		rows: [][]interface{}{
+			{"2022-01-01 00:00:00.000", "a", 1},
+			{"2022-01-01 00:01:00.000", "a", 2},
+		},
+	}
+}
+
+func TestQueryDataTimeColumnByType(t *testing.T) {
+	ds := newTestDatasource(t, newFakePresto(t, createdResult), nil)
+	tests := []struct {
+		format string
+		alert  bool
+		series bool
+	}{
+		{format: "time_series", series: true},
+		{format: "table"},
+		{format: "", alert: true},
+	}
+	for _, tt := range tests {
+		query := dataQuery(t, "A", map[string]interface{}{"rawSql": "SELECT created, host, value FROM t", "format": tt.format})
+		req := &backend.QueryDataRequest{Queries: []backend.DataQuery{query}}
+		if tt.alert {
+			req = alertRequest(query)
+		}
+		resp, err := ds.QueryData(context.Background(), req)
+		if err != nil {
+			t.Fatalf("QueryData() error = %v", err)
+		}
+		res := resp.Responses["A"]
+		if res.Error != nil {
+			t.Fatalf("query of format %q error = %v", tt.format, res.Error)
+		}
+		frame := res.Frames[0]
+		if series := frame.TimeSeriesSchema().Type == data.TimeSeriesTypeWide; series != tt.series {
+			t.Errorf("query of format %q returned a wide series = %v, want %v", tt.format, series, tt.series)
+		}
+		if !tt.series && (len(frame.Fields) != 3 || frame.Fields[1].Name != "host") {
+			t.Errorf("query of format %q changed the columns of the result to %d fields", tt.format, len(frame.Fields))
+		}
+	}
+}
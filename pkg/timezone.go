@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zones caches the locations loaded by loadZone, loading a location reads the zoneinfo database.
+var zones sync.Map
+
+// loadZone returns the location of a Presto time zone, either a zone name like America/New_York
+// or an offset like +05:30.
+func loadZone(zone string) (*time.Location, error) {
+	if loc, ok := zones.Load(zone); ok {
+		return loc.(*time.Location), nil
+	}
+	var loc *time.Location
+	if strings.HasPrefix(zone, "+") || strings.HasPrefix(zone, "-") {
+		offset, err := time.Parse("-07:00", zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone offset %q", zone)
+		}
+		_, seconds := offset.Zone()
+		loc = time.FixedZone(zone, seconds)
+	} else {
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", zone)
+		}
+	}
+	zones.Store(zone, loc)
+	return loc, nil
+}
+
+// parseTimestamp parses a Presto timestamp like 2022-01-01 12:30:00.123456789 in loc.
+// Fractions of a second beyond nanoseconds are truncated.
+func parseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	if dot := strings.IndexByte(s, '.'); dot != -1 {
+		end := dot + 1
+		for end < len(s) && '0' <= s[end] && s[end] <= '9' {
+			end++
+		}
+		if end-dot-1 > 9 {
+			s = s[:dot+10] + s[end:]
+		}
+	}
+	t, err := time.ParseInLocation(dateTimeFormat1, s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse timestamp %q", s)
+	}
+	return t, nil
+}
+
+// parseZonedTimestamp parses a Presto timestamp with time zone like 2022-01-01 12:30:00.123 UTC,
+// 2022-01-01 12:30:00.123 America/New_York or 2022-01-01 12:30:00.123 +05:30.
+func parseZonedTimestamp(s string) (time.Time, error) {
+	idx := strings.LastIndexByte(s, ' ')
+	if idx == -1 {
+		return time.Time{}, fmt.Errorf("cannot parse timestamp with time zone %q", s)
+	}
+	loc, err := loadZone(s[idx+1:])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse timestamp with time zone %q: %w", s, err)
+	}
+	return parseTimestamp(s[:idx], loc)
+}
+
+// parseTimeString parses the time of a string time column, with layout if set, else an RFC3339 time
+// or a Presto timestamp with or without time zone. Times without time zone are in loc.
+func parseTimeString(s string, layout string, loc *time.Location) (time.Time, error) {
+	if layout != "" {
+		t, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse time %q with layout %q", s, layout)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := parseTimestamp(s, loc); err == nil {
+		return t, nil
+	}
+	if t, err := parseZonedTimestamp(s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadZone(t *testing.T) {
+	tests := []struct {
+		zone   string
+		offset int
+		err    string
+	}{
+		{zone: "UTC"},
+		{zone: "Asia/Kolkata", offset: 19800},
+		{zone: "America/New_York", offset: -5 * 3600},
+		{zone: "+05:30", offset: 19800},
+		{zone: "-08:00", offset: -8 * 3600},
+		{zone: "+00:00"},
+		{zone: "Mars/Olympus_Mons", err: `unknown time zone "Mars/Olympus_Mons"`},
+		{zone: "New York", err: "unknown time zone"},
+		{zone: "+5:30", err: `invalid time zone offset "+5:30"`},
+		{zone: "+05:30:00", err: "invalid time zone offset"},
+	}
+	winter := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		loc, err := loadZone(tt.zone)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("loadZone(%q) error = %v, want %q", tt.zone, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadZone(%q) error = %v", tt.zone, err)
+			continue
+		}
+		if _, offset := winter.In(loc).Zone(); offset != tt.offset {
+			t.Errorf("loadZone(%q) has offset %d, want %d", tt.zone, offset, tt.offset)
+		}
+		if cached, _ := loadZone(tt.zone); cached != loc {
+			t.Errorf("loadZone(%q) did not return the cached location", tt.zone)
+		}
+	}
+}
+
+func TestParseZonedTimestamp(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		err   string
+	}{
+		{value: "2022-01-01 12:30:00 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30:00.123 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 123e6, time.UTC)},
+		{value: "2022-01-01 12:30:00.000 America/New_York", want: time.Date(2022, 1, 1, 17, 30, 0, 0, time.UTC)},
+		{value: "2022-07-01 12:30:00.000 America/New_York", want: time.Date(2022, 7, 1, 16, 30, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30:00.000 Asia/Kolkata", want: time.Date(2022, 1, 1, 7, 0, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30:00.000 +05:30", want: time.Date(2022, 1, 1, 7, 0, 0, 0, time.UTC)},
+		{value: "2022-01-01 02:30:00.000 -08:00", want: time.Date(2022, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30:00.1 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 100e6, time.UTC)},
+		{value: "2022-01-01 12:30:00.123456 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 123456e3, time.UTC)},
+		{value: "2022-01-01 12:30:00.123456789 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 123456789, time.UTC)},
+		{value: "2022-01-01 12:30:00.123456789123 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 123456789, time.UTC)},
+		{value: "2022-01-01 12:30:00.000 Mars/Olympus_Mons", err: `unknown time zone "Mars/Olympus_Mons"`},
+		{value: "2022-01-01 12:30:00.000 +5:30", err: "invalid time zone offset"},
+		{value: "2022-01-01T12:30:00Z", err: "cannot parse timestamp with time zone"},
+		{value: "2022-01-01 UTC", err: "cannot parse timestamp"},
+	}
+	for _, tt := range tests {
+		got, err := parseZonedTimestamp(tt.value)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseZonedTimestamp(%q) = %v, %v, want error %q", tt.value, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseZonedTimestamp(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	loc, err := loadZone("Asia/Kolkata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseTimestamp("2022-01-01 12:30:00.987654321", loc)
+	if want := time.Date(2022, 1, 1, 7, 0, 0, 987654321, time.UTC); err != nil || !got.Equal(want) {
+		t.Errorf("parseTimestamp() = %v, %v, want %v", got, err, want)
+	}
+	if got.Location() != loc {
+		t.Errorf("parseTimestamp() is in %v, want %v", got.Location(), loc)
+	}
+	if _, err := parseTimestamp("2022-01-01", loc); err == nil {
+		t.Error("parseTimestamp() of a date succeeded, want an error")
+	}
+}
+
+func TestParseTimeString(t *testing.T) {
+	loc, err := loadZone("+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		value  string
+		layout string
+		want   time.Time
+		err    string
+	}{
+		{value: "2022-01-01T12:30:00.5Z", want: time.Date(2022, 1, 1, 12, 30, 0, 500e6, time.UTC)},
+		{value: "2022-01-01T12:30:00+01:00", want: time.Date(2022, 1, 1, 11, 30, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30:00", want: time.Date(2022, 1, 1, 10, 30, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30:00.000 UTC", want: time.Date(2022, 1, 1, 12, 30, 0, 0, time.UTC)},
+		{value: "01/02/2022 12:30", layout: "01/02/2006 15:04", want: time.Date(2022, 1, 2, 10, 30, 0, 0, time.UTC)},
+		{value: "2022-01-01 12:30", layout: "01/02/2006 15:04", err: "with layout"},
+		{value: "yesterday", err: `cannot parse time "yesterday"`},
+	}
+	for _, tt := range tests {
+		got, err := parseTimeString(tt.value, tt.layout, loc)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseTimeString(%q, %q) = %v, %v, want error %q", tt.value, tt.layout, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTimeString(%q, %q) = %v, %v, want %v", tt.value, tt.layout, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const (
+	tokenRequestTimeout = 10 * time.Second
+	tokenRefreshMargin  = 30 * time.Second
+	tokenRetryMin       = 5 * time.Second
+	tokenRetryMax       = time.Minute
+)
+
+// tokenService fetches access tokens with the OAuth2 client credentials grant,
+// caches them and refreshes them in the background before they expire.
+type tokenService struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       string
+	client       *http.Client
+
+	// refreshMargin, retryMin and retryMax time the background refresh, see run.
+	refreshMargin time.Duration
+	retryMin      time.Duration
+	retryMax      time.Duration
+
+	// refreshMu serializes token requests, so concurrent queries don't stampede the token server.
+	refreshMu sync.Mutex
+	mu        sync.RWMutex
+	token     string
+	expiry    time.Time
+
+	done chan struct{}
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func newTokenService(tokenURL, clientID, clientSecret, scopes string, client *http.Client) (*tokenService, error) {
+	if tokenURL == "" {
+		return nil, errors.New("oauth2 authentication requires a token url")
+	}
+	if clientID == "" || clientSecret == "" {
+		return nil, errors.New("oauth2 authentication requires a client id and secret")
+	}
+	return &tokenService{
+		tokenURL:     tokenURL,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		scopes:       scopes,
+		client:       client,
+
+		refreshMargin: tokenRefreshMargin,
+		retryMin:      tokenRetryMin,
+		retryMax:      tokenRetryMax,
+
+		done: make(chan struct{}),
+	}, nil
+}
+
+// Start refreshes the token in the background until Close is called.
+func (s *tokenService) Start() {
+	go s.run()
+}
+
+func (s *tokenService) Close() {
+	close(s.done)
+}
+
+// Token returns the cached access token, fetching a new one if there is no valid token.
+func (s *tokenService) Token(ctx context.Context) (string, error) {
+	if token, ok := s.cached(); ok {
+		return token, nil
+	}
+	s.refreshMu.Lock()
+	defer s.refreshMu.Unlock()
+	if token, ok := s.cached(); ok {
+		return token, nil
+	}
+	if err := s.refresh(ctx); err != nil {
+		return "", err
+	}
+	token, _ := s.cached()
+	return token, nil
+}
+
+func (s *tokenService) cached() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.token, s.token != "" && time.Now().Before(s.expiry)
+}
+
+func (s *tokenService) run() {
+	retry := s.retryMin
+	for {
+		s.refreshMu.Lock()
+		err := s.refresh(context.Background())
+		s.refreshMu.Unlock()
+
+		var wait time.Duration
+		if err != nil {
+			wait = retry
+			if retry *= 2; retry > s.retryMax {
+				retry = s.retryMax
+			}
+		} else {
+			retry = s.retryMin
+			wait = s.refreshIn()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// refreshIn returns how long to wait before refreshing the current token.
+func (s *tokenService) refreshIn() time.Duration {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lifetime := time.Until(s.expiry)
+	if lifetime > 2*s.refreshMargin {
+		return lifetime - s.refreshMargin
+	}
+	if lifetime/2 < s.retryMin {
+		return s.retryMin
+	}
+	return lifetime / 2
+}
+
+// refresh fetches a new token, the caller must hold refreshMu.
+func (s *tokenService) refresh(ctx context.Context) error {
+	resp, err := s.fetch(ctx)
+	if err != nil {
+		tokenUpdateError.WithLabelValues(s.tokenURL).Inc()
+		backend.Logger.Error("Failed to update token.", "token_server", s.tokenURL, "err", err.Error())
+		return err
+	}
+	s.mu.Lock()
+	s.token = resp.AccessToken
+	s.expiry = time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	if resp.ExpiresIn <= 0 {
+		// tokens without expiry are refreshed regularly anyway
+		s.expiry = time.Now().Add(time.Hour)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *tokenService) fetch(ctx context.Context) (*tokenResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, tokenRequestTimeout)
+	defer cancel()
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if s.scopes != "" {
+		form.Set("scope", s.scopes)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request token failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read token response failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token server responded %s: %s", resp.Status, body)
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, fmt.Errorf("unable to parse token response: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("token response contains no access token")
+	}
+	if tr.TokenType != "" && !strings.EqualFold(tr.TokenType, "bearer") {
+		return nil, fmt.Errorf("unsupported token type %q", tr.TokenType)
+	}
+	return &tr, nil
+}
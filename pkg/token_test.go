@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// tokenServer is a token server answering the nth request with respond(n), n starting at 0.
+type tokenServer struct {
+	*httptest.Server
+	respond func(n int) (status int, body map[string]interface{})
+
+	mu       sync.Mutex
+	requests []*http.Request
+	times    []time.Time
+}
+
+func newTokenServer(t *testing.T, respond func(n int) (int, map[string]interface{})) *tokenServer {
+	s := &tokenServer{respond: respond}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		s.mu.Lock()
+		n := len(s.requests)
+		s.requests = append(s.requests, req)
+		s.times = append(s.times, time.Now())
+		s.mu.Unlock()
+		status, body := s.respond(n)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *tokenServer) requestTimes() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.times...)
+}
+
+// waitForRequests waits until the server received n requests.
+func (s *tokenServer) waitForRequests(t *testing.T, n int) []time.Time {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		times := s.requestTimes()
+		if len(times) >= n {
+			return times
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("token server received %d requests, want %d", len(times), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestTokenService(t *testing.T, server *tokenServer) *tokenService {
+	s, err := newTokenService(server.URL, "client id", "secret", "presto", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func bearerToken(token string, expiresIn int) map[string]interface{} {
+	return map[string]interface{}{"access_token": token, "token_type": "bearer", "expires_in": expiresIn}
+}
+
+func TestTokenServiceFirstFetch(t *testing.T) {
+	server := newTokenServer(t, func(int) (int, map[string]interface{}) {
+		return http.StatusOK, bearerToken("t1", 3600)
+	})
+	s := newTestTokenService(t, server)
+
+	for i := 0; i < 2; i++ {
+		token, err := s.Token(context.Background())
+		if err != nil || token != "t1" {
+			t.Fatalf("Token() = %q, %v, want t1", token, err)
+		}
+	}
+	if n := len(server.requestTimes()); n != 1 {
+		t.Fatalf("token server received %d requests, want the token to be cached", n)
+	}
+	server.mu.Lock()
+	req := server.requests[0]
+	server.mu.Unlock()
+	if req.PostForm.Get("grant_type") != "client_credentials" || req.PostForm.Get("scope") != "presto" {
+		t.Errorf("token request form = %v", req.PostForm)
+	}
+	if user, password, ok := req.BasicAuth(); !ok || user != "client+id" || password != "secret" {
+		t.Errorf("token request credentials = %q, %q, %v", user, password, ok)
+	}
+}
+
+func TestTokenServiceRefreshBeforeExpiry(t *testing.T) {
+	server := newTokenServer(t, func(n int) (int, map[string]interface{}) {
+		return http.StatusOK, bearerToken([]string{"t1", "t2", "t3"}[n%3], 1)
+	})
+	s := newTestTokenService(t, server)
+	s.refreshMargin = 400 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	times := server.waitForRequests(t, 2)
+	if gap := times[1].Sub(times[0]); gap >= time.Second {
+		t.Errorf("token was refreshed after %v, not before it expired", gap)
+	}
+	if token, err := s.Token(context.Background()); err != nil || token == "t1" {
+		t.Errorf("Token() = %q, %v, want the refreshed token", token, err)
+	}
+}
+
+func TestTokenServiceBackoff(t *testing.T) {
+	const failures = 4
+	server := newTokenServer(t, func(n int) (int, map[string]interface{}) {
+		if n < failures {
+			return http.StatusInternalServerError, map[string]interface{}{"error": "unavailable"}
+		}
+		return http.StatusOK, bearerToken("t1", 3600)
+	})
+	s := newTestTokenService(t, server)
+	s.retryMin = 20 * time.Millisecond
+	s.retryMax = 50 * time.Millisecond
+
+	if _, err := s.Token(context.Background()); err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("Token() error = %v, want the error of the token server", err)
+	}
+	updateErrors := testutil.ToFloat64(tokenUpdateError.WithLabelValues(server.URL))
+	if updateErrors != 1 {
+		t.Errorf("token update errors = %v, want 1", updateErrors)
+	}
+
+	s.Start()
+	defer s.Close()
+	times := server.waitForRequests(t, failures+1)
+	// the first request was made by Token, the background refresh retried after 20, 40 and 50ms
+	for i, want := range []time.Duration{20, 40, 50} {
+		if gap := times[i+2].Sub(times[i+1]); gap < want*time.Millisecond {
+			t.Errorf("retry %d after %v, want at least %vms", i+1, gap, want)
+		}
+	}
+	if updateErrors := testutil.ToFloat64(tokenUpdateError.WithLabelValues(server.URL)); updateErrors != failures {
+		t.Errorf("token update errors = %v, want %d", updateErrors, failures)
+	}
+	if token, err := s.Token(context.Background()); err != nil || token != "t1" {
+		t.Errorf("Token() = %q, %v, want t1", token, err)
+	}
+}
+
+func TestTokenServiceTokenType(t *testing.T) {
+	tests := []struct {
+		tokenType string
+		err       bool
+	}{
+		{tokenType: "bearer"},
+		{tokenType: "Bearer"},
+		{tokenType: ""},
+		{tokenType: "mac", err: true},
+		{tokenType: "N_A", err: true},
+	}
+	for _, tt := range tests {
+		server := newTokenServer(t, func(int) (int, map[string]interface{}) {
+			return http.StatusOK, map[string]interface{}{"access_token": "t1", "token_type": tt.tokenType}
+		})
+		token, err := newTestTokenService(t, server).Token(context.Background())
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "unsupported token type") {
+				t.Errorf("Token() of token type %q = %q, %v, want an error", tt.tokenType, token, err)
+			}
+		} else if err != nil || token != "t1" {
+			t.Errorf("Token() of token type %q = %q, %v, want t1", tt.tokenType, token, err)
+		}
+	}
+}
+
+func TestTokenServiceRefreshIn(t *testing.T) {
+	s := &tokenService{refreshMargin: 30 * time.Second, retryMin: 5 * time.Second}
+	tests := []struct {
+		lifetime time.Duration
+		min, max time.Duration
+	}{
+		{lifetime: time.Hour, min: time.Hour - 31*time.Second, max: time.Hour - 30*time.Second},
+		{lifetime: 40 * time.Second, min: 19 * time.Second, max: 20 * time.Second},
+		{lifetime: 4 * time.Second, min: 5 * time.Second, max: 5 * time.Second},
+		{lifetime: -time.Minute, min: 5 * time.Second, max: 5 * time.Second},
+	}
+	for _, tt := range tests {
+		s.expiry = time.Now().Add(tt.lifetime)
+		if got := s.refreshIn(); got < tt.min || got > tt.max {
+			t.Errorf("refreshIn() with a lifetime of %v = %v, want between %v and %v", tt.lifetime, got, tt.min, tt.max)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type sqlTokenKind int
+
+const (
+	tokenWord sqlTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenQuotedIdentifier
+	tokenComment
+	tokenSymbol
+	tokenSemicolon
+)
+
+// sqlToken is a token of a Presto SQL text, whitespace is not kept.
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+	pos  int
+}
+
+// keyword returns the upper case text of a word token, and an empty string for other tokens.
+func (t sqlToken) keyword() string {
+	if t.kind != tokenWord {
+		return ""
+	}
+	return strings.ToUpper(t.text)
+}
+
+// tokenizeSQL splits sql into tokens. It knows just enough of the Presto syntax to tell
+// keywords from string literals, quoted identifiers and comments.
+func tokenizeSQL(sql string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql)
+			} else {
+				i += end
+			}
+			tokens = append(tokens, sqlToken{kind: tokenComment, text: sql[start:i], pos: start})
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment at position %d", start)
+			}
+			i += end + 4
+			tokens = append(tokens, sqlToken{kind: tokenComment, text: sql[start:i], pos: start})
+		case c == '\'' || c == '"':
+			end, err := quoteEnd(sql, i)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			kind := tokenString
+			if c == '"' {
+				kind = tokenQuotedIdentifier
+			}
+			tokens = append(tokens, sqlToken{kind: kind, text: sql[start:i], pos: start})
+		case c == ';':
+			i++
+			tokens = append(tokens, sqlToken{kind: tokenSemicolon, text: ";", pos: start})
+		case '0' <= c && c <= '9':
+			for i < len(sql) && (isIdentifierChar(sql[i]) || sql[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenNumber, text: sql[start:i], pos: start})
+		case isIdentifierChar(c) || c >= 0x80:
+			for i < len(sql) && (isIdentifierChar(sql[i]) || sql[i] >= 0x80) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{kind: tokenWord, text: sql[start:i], pos: start})
+		default:
+			i++
+			tokens = append(tokens, sqlToken{kind: tokenSymbol, text: sql[start:i], pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+// quoteEnd returns the index after the quoted string starting at start, quotes are escaped by doubling them.
+func quoteEnd(sql string, start int) (int, error) {
+	quote := sql[start]
+	for i := start + 1; i < len(sql); i++ {
+		if sql[i] != quote {
+			continue
+		}
+		if i+1 < len(sql) && sql[i+1] == quote {
+			i++
+			continue
+		}
+		return i + 1, nil
+	}
+	return -1, fmt.Errorf("unterminated quoted string at position %d", start)
+}
+
+type statementKind string
+
+const (
+	statementSelect   statementKind = "SELECT"
+	statementShow     statementKind = "SHOW"
+	statementDescribe statementKind = "DESCRIBE"
+	statementExplain  statementKind = "EXPLAIN"
+)
+
+// sqlStatement is a single statement of a SQL text. Its tokens don't include comments and the
+// terminating semicolon, its text starts at the first and ends with the last token.
+type sqlStatement struct {
+	kind   statementKind
+	tokens []sqlToken
+	text   string
+}
+
+// parseStatements splits sql into its statements, empty statements are skipped.
+func parseStatements(sql string) ([]sqlStatement, error) {
+	tokens, err := tokenizeSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+	var statements []sqlStatement
+	var current []sqlToken
+	flush := func() {
+		if len(current) > 0 {
+			first, last := current[0], current[len(current)-1]
+			statements = append(statements, sqlStatement{
+				kind:   classifyStatement(current),
+				tokens: current,
+				text:   sql[first.pos : last.pos+len(last.text)],
+			})
+			current = nil
+		}
+	}
+	for _, token := range tokens {
+		switch token.kind {
+		case tokenComment:
+		case tokenSemicolon:
+			flush()
+		default:
+			current = append(current, token)
+		}
+	}
+	flush()
+	return statements, nil
+}
+
+// classifyStatement returns the kind of a statement from its leading keyword.
+func classifyStatement(tokens []sqlToken) statementKind {
+	first := tokens[0]
+	if first.kind == tokenSymbol && first.text == "(" {
+		return statementSelect
+	}
+	switch keyword := first.keyword(); keyword {
+	case "SELECT", "WITH", "VALUES", "TABLE":
+		return statementSelect
+	case "SHOW":
+		return statementShow
+	case "DESCRIBE", "DESC":
+		return statementDescribe
+	case "EXPLAIN":
+		return statementExplain
+	default:
+		return statementKind(keyword)
+	}
+}
+
+// readOnly reports whether the statement only reads data. EXPLAIN ANALYZE runs the explained
+// statement, so it is read only if that statement is.
+func (s sqlStatement) readOnly() bool {
+	switch s.kind {
+	case statementSelect, statementShow, statementDescribe:
+		return true
+	case statementExplain:
+		rest := s.tokens[1:]
+		if len(rest) == 0 || rest[0].keyword() != "ANALYZE" {
+			return true
+		}
+		rest = rest[1:]
+		if len(rest) > 0 && rest[0].keyword() == "VERBOSE" {
+			rest = rest[1:]
+		}
+		return len(rest) > 0 && sqlStatement{kind: classifyStatement(rest), tokens: rest}.readOnly()
+	}
+	return false
+}
+
+// withLimit returns the text of the statement changed to return at most n rows. The LIMIT of a
+// query is tightened or appended. ok is false if the statement can't be limited in SQL, e.g.
+// SHOW statements or queries ending with FETCH FIRST.
+func (s sqlStatement) withLimit(n int64) (text string, ok bool) {
+	if s.kind != statementSelect {
+		return s.text, false
+	}
+	tokens := s.tokens
+	last := tokens[len(tokens)-1]
+	if last.keyword() == "ONLY" || last.keyword() == "TIES" {
+		return s.text, false
+	}
+	if len(tokens) >= 2 && tokens[len(tokens)-2].keyword() == "LIMIT" && depthAt(tokens, len(tokens)-2) == 0 {
+		if last.kind == tokenNumber {
+			if current, err := strconv.ParseInt(last.text, 10, 64); err == nil && current <= n {
+				return s.text, true
+			}
+		} else if last.keyword() != "ALL" {
+			return s.text, false
+		}
+		start := tokens[0].pos
+		return s.text[:last.pos-start] + strconv.FormatInt(n, 10), true
+	}
+	return fmt.Sprintf("%s LIMIT %d", s.text, n), true
+}
+
+// depthAt returns the parenthesis depth of the token at index i.
+func depthAt(tokens []sqlToken, i int) int {
+	depth := 0
+	for _, token := range tokens[:i] {
+		if token.kind == tokenSymbol {
+			switch token.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+		}
+	}
+	return depth
+}
+
+// checkStatement parses the SQL of a query, which must be a single statement. Datasources
+// set to read only reject statements other than SELECT, WITH, SHOW, DESCRIBE and EXPLAIN.
+func (ds *PrestoDatasource) checkStatement(sql string) (sqlStatement, error) {
+	statements, err := parseStatements(sql)
+	if err != nil {
+		return sqlStatement{}, fmt.Errorf("invalid query: %w", err)
+	}
+	switch len(statements) {
+	case 0:
+		return sqlStatement{}, errors.New("query is empty")
+	case 1:
+	default:
+		return sqlStatement{}, fmt.Errorf("query contains %d statements, only a single statement is supported", len(statements))
+	}
+	statement := statements[0]
+	if ds.settings.PrestoParam.ReadOnly && !statement.readOnly() {
+		return sqlStatement{}, fmt.Errorf("%s statements are not allowed, the datasource is read only", statement.kind)
+	}
+	return statement, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStatements(t *testing.T) {
+	tests := []struct {
+		sql   string
+		texts []string
+	}{
+		{sql: "SELECT 1", texts: []string{"SELECT 1"}},
+		{sql: "SELECT 1;", texts: []string{"SELECT 1"}},
+		{sql: "  SELECT 1 ; ; -- done", texts: []string{"SELECT 1"}},
+		{sql: "SELECT 1; DROP TABLE t", texts: []string{"SELECT 1", "DROP TABLE t"}},
+		{sql: "SELECT 'a;b', \"c;d\" FROM t", texts: []string{`SELECT 'a;b', "c;d" FROM t`}},
+		{sql: "SELECT 1 -- ; DROP TABLE t\nFROM t", texts: []string{"SELECT 1 -- ; DROP TABLE t\nFROM t"}},
+		{sql: "/* ; */ SELECT 1 /* ; */", texts: []string{"SELECT 1"}},
+		{sql: "-- only a comment", texts: nil},
+	}
+	for _, tt := range tests {
+		statements, err := parseStatements(tt.sql)
+		if err != nil {
+			t.Errorf("parseStatements(%q) error = %v", tt.sql, err)
+			continue
+		}
+		var texts []string
+		for _, s := range statements {
+			texts = append(texts, s.text)
+		}
+		if strings.Join(texts, "|") != strings.Join(tt.texts, "|") || len(texts) != len(tt.texts) {
+			t.Errorf("parseStatements(%q) = %q, want %q", tt.sql, texts, tt.texts)
+		}
+	}
+
+	for _, sql := range []string{"SELECT 'a", `SELECT "a`, "SELECT 1 /* a"} {
+		if _, err := parseStatements(sql); err == nil {
+			t.Errorf("parseStatements(%q) succeeded, want an error", sql)
+		}
+	}
+}
+
+func TestCheckStatementReadOnly(t *testing.T) {
+	tests := []struct {
+		sql string
+		err string
+	}{
+		{sql: "SELECT * FROM t"},
+		{sql: "select * from t"},
+		{sql: "WITH a AS (SELECT 1) SELECT * FROM a"},
+		{sql: "(SELECT 1) UNION (SELECT 2)"},
+		{sql: "VALUES 1, 2"},
+		{sql: "TABLE t"},
+		{sql: "SHOW TABLES"},
+		{sql: "DESCRIBE t"},
+		{sql: "DESC t"},
+		{sql: "EXPLAIN INSERT INTO t SELECT * FROM s"},
+		{sql: "EXPLAIN (TYPE DISTRIBUTED) DELETE FROM t"},
+		{sql: "EXPLAIN ANALYZE SELECT * FROM t"},
+		{sql: "EXPLAIN ANALYZE VERBOSE WITH a AS (SELECT 1) SELECT * FROM a"},
+		{sql: "SELECT 'DELETE FROM t' AS s"},
+		{sql: `SELECT "insert" FROM t`},
+		{sql: "/* DROP TABLE t */ SELECT 1"},
+		{sql: "-- DELETE FROM t\nSELECT 1"},
+		{sql: "SELECT 1 -- ; DROP TABLE t"},
+		{sql: "SELECT 1;"},
+		{sql: "INSERT INTO t SELECT * FROM s", err: "INSERT statements are not allowed"},
+		{sql: "insert into t values (1)", err: "INSERT statements are not allowed"},
+		{sql: "CREATE TABLE t AS SELECT * FROM s", err: "CREATE statements are not allowed"},
+		{sql: "DELETE FROM t", err: "DELETE statements are not allowed"},
+		{sql: "DROP TABLE t", err: "DROP statements are not allowed"},
+		{sql: "EXPLAIN ANALYZE INSERT INTO t SELECT * FROM s", err: "EXPLAIN statements are not allowed"},
+		{sql: "EXPLAIN ANALYZE VERBOSE DELETE FROM t", err: "EXPLAIN statements are not allowed"},
+		{sql: "EXPLAIN ANALYZE CREATE TABLE t AS SELECT 1", err: "EXPLAIN statements are not allowed"},
+		{sql: "EXPLAIN ANALYZE", err: "EXPLAIN statements are not allowed"},
+		{sql: "CALL system.runtime.kill_query('q1')", err: "CALL statements are not allowed"},
+		{sql: "SET SESSION query_max_run_time = '1h'", err: "SET statements are not allowed"},
+		{sql: "RESET SESSION query_max_run_time", err: "RESET statements are not allowed"},
+		{sql: "/* SELECT */ DELETE FROM t", err: "DELETE statements are not allowed"},
+		{sql: "-- SELECT\nDELETE FROM t", err: "DELETE statements are not allowed"},
+		{sql: "SELECT 1; DELETE FROM t", err: "query contains 2 statements"},
+		{sql: "SELECT 1; SELECT 2", err: "query contains 2 statements"},
+		{sql: "SELECT ';'; DELETE FROM t", err: "query contains 2 statements"},
+		{sql: "", err: "query is empty"},
+		{sql: " ; -- nothing", err: "query is empty"},
+		{sql: "SELECT 'DELETE", err: "invalid query"},
+	}
+	ds := &PrestoDatasource{settings: &DatasourceSettings{PrestoParam: PrestoParam{ReadOnly: true}}}
+	for _, tt := range tests {
+		_, err := ds.checkStatement(tt.sql)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("checkStatement(%q) error = %v", tt.sql, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("checkStatement(%q) error = %v, want %q", tt.sql, err, tt.err)
+		}
+	}
+}
+
+func TestCheckStatementWritable(t *testing.T) {
+	ds := &PrestoDatasource{settings: &DatasourceSettings{}}
+	for _, sql := range []string{"INSERT INTO t SELECT * FROM s", "CALL system.sync_partition_metadata('s', 't', 'ADD')"} {
+		if _, err := ds.checkStatement(sql); err != nil {
+			t.Errorf("checkStatement(%q) error = %v of a datasource which isn't read only", sql, err)
+		}
+	}
+	if _, err := ds.checkStatement("SELECT 1; INSERT INTO t VALUES (1)"); err == nil {
+		t.Error("checkStatement() of multiple statements succeeded, want an error")
+	}
+}
+
+func TestWithLimit(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+		ok   bool
+	}{
+		{sql: "SELECT * FROM t", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t;", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t ; -- trailing", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t -- trailing", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t /* trailing */", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t\n-- LIMIT 5\n", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t LIMIT 10", want: "SELECT * FROM t LIMIT 10", ok: true},
+		{sql: "SELECT * FROM t LIMIT 100", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t LIMIT 1000", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "select * from t limit 1000;", want: "select * from t limit 100", ok: true},
+		{sql: "SELECT * FROM t LIMIT 99999999999999999999", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t LIMIT ALL", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t LIMIT 1000 -- rows", want: "SELECT * FROM t LIMIT 100", ok: true},
+		{sql: "SELECT * FROM (SELECT * FROM t LIMIT 10)", want: "SELECT * FROM (SELECT * FROM t LIMIT 10) LIMIT 100", ok: true},
+		{sql: "SELECT * FROM (SELECT * FROM t LIMIT 1000) s", want: "SELECT * FROM (SELECT * FROM t LIMIT 1000) s LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t WHERE x IN (SELECT x FROM s LIMIT 1000)", want: "SELECT * FROM t WHERE x IN (SELECT x FROM s LIMIT 1000) LIMIT 100", ok: true},
+		{sql: "WITH a AS (SELECT * FROM t LIMIT 5) SELECT * FROM a", want: "WITH a AS (SELECT * FROM t LIMIT 5) SELECT * FROM a LIMIT 100", ok: true},
+		{sql: "SELECT 1 UNION ALL SELECT 2 LIMIT 1000", want: "SELECT 1 UNION ALL SELECT 2 LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t ORDER BY x OFFSET 10", want: "SELECT * FROM t ORDER BY x OFFSET 10 LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t OFFSET 10 LIMIT 1000", want: "SELECT * FROM t OFFSET 10 LIMIT 100", ok: true},
+		{sql: "SELECT 'LIMIT 5' AS s", want: "SELECT 'LIMIT 5' AS s LIMIT 100", ok: true},
+		{sql: "VALUES 1, 2", want: "VALUES 1, 2 LIMIT 100", ok: true},
+		{sql: "SELECT * FROM t FETCH FIRST 10 ROWS ONLY", want: "SELECT * FROM t FETCH FIRST 10 ROWS ONLY"},
+		{sql: "SELECT * FROM t ORDER BY x FETCH FIRST 10 ROWS WITH TIES", want: "SELECT * FROM t ORDER BY x FETCH FIRST 10 ROWS WITH TIES"},
+		{sql: "SELECT * FROM t LIMIT ?", want: "SELECT * FROM t LIMIT ?"},
+		{sql: "SHOW TABLES", want: "SHOW TABLES"},
+		{sql: "EXPLAIN SELECT * FROM t", want: "EXPLAIN SELECT * FROM t"},
+	}
+	for _, tt := range tests {
+		statements, err := parseStatements(tt.sql)
+		if err != nil || len(statements) != 1 {
+			t.Errorf("parseStatements(%q) = %d statements, %v", tt.sql, len(statements), err)
+			continue
+		}
+		got, ok := statements[0].withLimit(100)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("withLimit(%q) = %q, %v, want %q, %v", tt.sql, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestDepthAt(t *testing.T) {
+	tokens, err := tokenizeSQL("SELECT (a, (b)) FROM t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []int{0, 0, 1, 1, 1, 2, 2, 1, 0, 0}
+	if len(tokens) != len(want) {
+		t.Fatalf("tokenizeSQL() returned %d tokens, want %d", len(tokens), len(want))
+	}
+	for i, token := range tokens {
+		if got := depthAt(tokens, i); got != want[i] {
+			t.Errorf("depthAt(%d) of %q = %d, want %d", i, token.text, got, want[i])
+		}
+	}
+}
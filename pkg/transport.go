@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	authTypeBasic  = "basic"
+	authTypeToken  = "token"
+	authTypeOAuth2 = "oauth2"
+)
+
+// newHTTPClient creates the client registered as custom client of the presto driver,
+// so that every request sent to Presto carries the datasource credentials and TLS settings.
+// tokens must be set for the oauth2 auth type.
+func newHTTPClient(settings *DatasourceSettings, tokens *tokenService) (*http.Client, error) {
+	transport, err := newTLSTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	secure := settings.Instance.DecryptedSecureJSONData
+	rt := &authTransport{base: transport}
+	switch settings.PrestoParam.AuthType {
+	case "":
+	case authTypeBasic:
+		rt.user = settings.Instance.BasicAuthUser
+		rt.password = secure["password"]
+	case authTypeToken:
+		if secure["token"] == "" {
+			return nil, errors.New("token authentication requires a token")
+		}
+		rt.token = secure["token"]
+	case authTypeOAuth2:
+		if tokens == nil {
+			return nil, errors.New("oauth2 authentication requires a token service")
+		}
+		rt.tokens = tokens
+	default:
+		return nil, fmt.Errorf("unknown auth type %q", settings.PrestoParam.AuthType)
+	}
+	var base http.RoundTripper = rt
+	if zone := settings.PrestoParam.SessionTimeZone; zone != "" {
+		base = &headerTransport{base: rt, headers: map[string]string{"X-Presto-Time-Zone": zone}}
+	}
+	return &http.Client{Transport: &columnTypeTransport{base: base}}, nil
+}
+
+// checkAuthScheme refuses to send credentials in cleartext, like the presto driver does,
+// unless AllowInsecureAuth is set.
+func (s *DatasourceSettings) checkAuthScheme() error {
+	param := s.PrestoParam
+	if param.AllowInsecureAuth {
+		return nil
+	}
+	credentials := param.AuthType == authTypeToken || param.AuthType == authTypeOAuth2 ||
+		param.AuthType == authTypeBasic && s.Instance.DecryptedSecureJSONData["password"] != ""
+	if credentials && !strings.EqualFold(param.HTTPScheme, "https") {
+		return fmt.Errorf("%s authentication requires the https scheme, got %q", param.AuthType, param.HTTPScheme)
+	}
+	if param.AuthType == authTypeOAuth2 {
+		if u, err := url.Parse(param.OAuth2TokenURL); err == nil && u.Scheme != "" && !strings.EqualFold(u.Scheme, "https") {
+			return fmt.Errorf("oauth2 token url must use https, got %q", param.OAuth2TokenURL)
+		}
+	}
+	return nil
+}
+
+// newTokenClient creates the client of the token service, the token url is usually
+// served behind the same CA as Presto.
+func newTokenClient(settings *DatasourceSettings) (*http.Client, error) {
+	transport, err := newTLSTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport, Timeout: tokenRequestTimeout}, nil
+}
+
+// newTLSTransport returns a transport with the TLS settings of the datasource.
+func newTLSTransport(settings *DatasourceSettings) (*http.Transport, error) {
+	tlsConfig, err := newTLSConfig(settings)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+func newTLSConfig(settings *DatasourceSettings) (*tls.Config, error) {
+	secure := settings.Instance.DecryptedSecureJSONData
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: settings.PrestoParam.TLSSkipVerify,
+	}
+	if settings.PrestoParam.TLSAuthWithCACert {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(secure["tlsCACert"])) {
+			return nil, errors.New("failed to parse TLS CA certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if settings.PrestoParam.TLSAuth {
+		cert, err := tls.X509KeyPair([]byte(secure["tlsClientCert"]), []byte(secure["tlsClientKey"]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// authTransport authenticates requests with either a bearer token, a token of
+// the token service or basic auth.
+type authTransport struct {
+	base     http.RoundTripper
+	user     string
+	password string
+	token    string
+	tokens   *tokenService
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.token
+	if t.tokens != nil {
+		var err error
+		if token, err = t.tokens.Token(req.Context()); err != nil {
+			return nil, fmt.Errorf("get access token failed: %w", err)
+		}
+	}
+	if token == "" && t.password == "" {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(t.user, t.password)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// headerTransport sets fixed headers on every request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
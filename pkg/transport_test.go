@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestNewDatasourceInstanceAuthScheme(t *testing.T) {
+	tests := []struct {
+		name     string
+		jsonData map[string]interface{}
+		secure   map[string]string
+		err      string
+	}{
+		{name: "no auth over http", jsonData: map[string]interface{}{"HTTPScheme": "http"}},
+		{name: "basic auth without password over http", jsonData: map[string]interface{}{"HTTPScheme": "http", "AuthType": "basic"}},
+		{
+			name:     "basic auth over http",
+			jsonData: map[string]interface{}{"HTTPScheme": "http", "AuthType": "basic"},
+			secure:   map[string]string{"password": "secret"},
+			err:      "basic authentication requires the https scheme",
+		},
+		{
+			name:     "token over http",
+			jsonData: map[string]interface{}{"HTTPScheme": "http", "AuthType": "token"},
+			secure:   map[string]string{"token": "t1"},
+			err:      "token authentication requires the https scheme",
+		},
+		{
+			name:     "oauth2 over http",
+			jsonData: map[string]interface{}{"HTTPScheme": "http", "AuthType": "oauth2", "OAuth2TokenURL": "https://auth.example.com/token"},
+			secure:   map[string]string{"clientId": "id", "clientSecret": "secret"},
+			err:      "oauth2 authentication requires the https scheme",
+		},
+		{
+			name:     "oauth2 with http token url",
+			jsonData: map[string]interface{}{"HTTPScheme": "https", "AuthType": "oauth2", "OAuth2TokenURL": "http://auth.example.com/token"},
+			secure:   map[string]string{"clientId": "id", "clientSecret": "secret"},
+			err:      "oauth2 token url must use https",
+		},
+		{
+			name:     "token over https",
+			jsonData: map[string]interface{}{"HTTPScheme": "HTTPS", "AuthType": "token"},
+			secure:   map[string]string{"token": "t1"},
+		},
+		{
+			name:     "token over http allowed",
+			jsonData: map[string]interface{}{"HTTPScheme": "http", "AuthType": "token", "AllowInsecureAuth": true},
+			secure:   map[string]string{"token": "t1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.jsonData["Host"] = "localhost:8080"
+			b, err := json.Marshal(tt.jsonData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			instance, err := NewDatasourceInstance(backend.DataSourceInstanceSettings{
+				Name:                    strings.ReplaceAll(t.Name(), "/", "_"),
+				JSONData:                b,
+				DecryptedSecureJSONData: tt.secure,
+			})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("NewDatasourceInstance() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewDatasourceInstance() error = %v", err)
+			}
+			instance.(*PrestoDatasource).Dispose()
+		})
+	}
+}
+
+func TestNewDatasourceInstanceTokenTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(bearerToken("t1", 3600))
+	}))
+	defer server.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	tests := []struct {
+		name     string
+		jsonData map[string]interface{}
+		secure   map[string]string
+		err      string
+	}{
+		{name: "ca certificate", jsonData: map[string]interface{}{"TLSAuthWithCACert": true}, secure: map[string]string{"tlsCACert": string(caCert)}},
+		{name: "skip verify", jsonData: map[string]interface{}{"TLSSkipVerify": true}},
+		{name: "unknown authority", jsonData: map[string]interface{}{}, err: "certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.jsonData["HTTPScheme"] = "https"
+			tt.jsonData["Host"] = "localhost:8080"
+			tt.jsonData["AuthType"] = "oauth2"
+			tt.jsonData["OAuth2TokenURL"] = server.URL + "/token"
+			b, err := json.Marshal(tt.jsonData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			secure := map[string]string{"clientId": "id", "clientSecret": "secret"}
+			for name, value := range tt.secure {
+				secure[name] = value
+			}
+			instance, err := NewDatasourceInstance(backend.DataSourceInstanceSettings{
+				Name:                    strings.ReplaceAll(t.Name(), "/", "_"),
+				JSONData:                b,
+				DecryptedSecureJSONData: secure,
+			})
+			if err != nil {
+				t.Fatalf("NewDatasourceInstance() error = %v", err)
+			}
+			ds := instance.(*PrestoDatasource)
+			defer ds.Dispose()
+
+			token, err := ds.tokens.Token(context.Background())
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Token() error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil || token != "t1" {
+				t.Errorf("Token() = %q, %v, want t1", token, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// sketchTypeRegex matches the types of approximation sketches, which Presto sends base64 encoded.
+var sketchTypeRegex = regexp.MustCompile(`(?i)^(hyperloglog|p4hyperloglog|qdigest|tdigest|kllsketch|setdigest)\b`)
+
+// typeFieldConfigs are the field configs of the columns of a type.
+var typeFieldConfigs = map[string]*data.FieldConfig{
+	"interval day to second": {Unit: "ms"},
+	"interval year to month": {Description: "Months"},
+}
+
+// scanBinary converts a varbinary, which Presto sends base64 encoded, to hex.
+func scanBinary(in interface{}) (interface{}, error) {
+	ns := in.(*sql.NullString)
+	if !ns.Valid {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(ns.String)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode varbinary: %w", err)
+	}
+	v := hex.EncodeToString(b)
+	return &v, nil
+}
+
+// parseIntervalDayToSecond returns the milliseconds of an interval like -1 02:03:04.567.
+func parseIntervalDayToSecond(s string) (int64, error) {
+	invalid := fmt.Errorf("cannot parse interval day to second %q", s)
+	negative := strings.HasPrefix(s, "-")
+	parts := strings.Fields(strings.TrimPrefix(s, "-"))
+	if len(parts) != 2 {
+		return 0, invalid
+	}
+	days, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, invalid
+	}
+	clock := strings.Split(parts[1], ":")
+	if len(clock) != 3 {
+		return 0, invalid
+	}
+	hours, err1 := strconv.ParseInt(clock[0], 10, 64)
+	minutes, err2 := strconv.ParseInt(clock[1], 10, 64)
+	seconds, err3 := strconv.ParseFloat(clock[2], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, invalid
+	}
+	ms := ((days*24+hours)*60+minutes)*60*1000 + int64(seconds*1000+0.5)
+	if negative {
+		ms = -ms
+	}
+	return ms, nil
+}
+
+// parseIntervalYearToMonth returns the months of an interval like -1-2.
+func parseIntervalYearToMonth(s string) (int64, error) {
+	invalid := fmt.Errorf("cannot parse interval year to month %q", s)
+	negative := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), "-")
+	if len(parts) != 2 {
+		return 0, invalid
+	}
+	years, err1 := strconv.ParseInt(parts[0], 10, 64)
+	months, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, invalid
+	}
+	total := years*12 + months
+	if negative {
+		total = -total
+	}
+	return total, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseIntervalDayToSecond(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{value: "0 00:00:00.000", want: 0},
+		{value: "1 02:03:04.567", want: ((26*60+3)*60+4)*1000 + 567},
+		{value: "-1 02:03:04.567", want: -(((26*60+3)*60+4)*1000 + 567)},
+		{value: "-0 00:00:01.500", want: -1500},
+		{value: "0 00:00:00.001", want: 1},
+		{value: "10000 00:00:00.000", want: 10000 * 24 * 3600 * 1000},
+		{value: "1 02:03:04", want: ((26*60+3)*60 + 4) * 1000},
+		{value: "", err: true},
+		{value: "02:03:04.567", err: true},
+		{value: "1 02:03", err: true},
+		{value: "x 02:03:04.567", err: true},
+		{value: "1 02:xx:04.567", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseIntervalDayToSecond(tt.value)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "cannot parse interval day to second") {
+				t.Errorf("parseIntervalDayToSecond(%q) = %d, %v, want an error", tt.value, got, err)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("parseIntervalDayToSecond(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseIntervalYearToMonth(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		err   bool
+	}{
+		{value: "0-0", want: 0},
+		{value: "1-2", want: 14},
+		{value: "-1-2", want: -14},
+		{value: "-0-3", want: -3},
+		{value: "100-11", want: 1211},
+		{value: "", err: true},
+		{value: "14", err: true},
+		{value: "1-2-3", err: true},
+		{value: "1-x", err: true},
+		{value: "--1-2", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseIntervalYearToMonth(tt.value)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "cannot parse interval year to month") {
+				t.Errorf("parseIntervalYearToMonth(%q) = %d, %v, want an error", tt.value, got, err)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("parseIntervalYearToMonth(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestScanBinary(t *testing.T) {
+	tests := []struct {
+		value *sql.NullString
+		want  interface{}
+		err   bool
+	}{
+		{value: &sql.NullString{String: "AAEC/w==", Valid: true}, want: "000102ff"},
+		{value: &sql.NullString{String: "", Valid: true}, want: ""},
+		{value: &sql.NullString{}, want: nil},
+		{value: &sql.NullString{String: "not base64!", Valid: true}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := scanBinary(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("scanBinary(%q) = %v, want an error", tt.value.String, got)
+			}
+			continue
+		}
+		if err != nil || derefString(got) != tt.want {
+			t.Errorf("scanBinary(%+v) = %v, %v, want %v", *tt.value, derefString(got), err, tt.want)
+		}
+	}
+}
+
+func TestTypeConverters(t *testing.T) {
+	tests := []struct {
+		typeName string
+		value    string
+		want     interface{}
+		err      string
+	}{
+		{typeName: "uuid", value: "12151FD2-7586-11E9-8F9E-2A86E4085A59", want: "12151fd2-7586-11e9-8f9e-2a86e4085a59"},
+		{typeName: "ipaddress", value: "10.0.0.1", want: "10.0.0.1"},
+		{typeName: "ipaddress", value: "2001:0db8:0000:0000:0000:0000:0000:0001", want: "2001:db8::1"},
+		{typeName: "ipaddress", value: "::ffff:10.0.0.1", want: "10.0.0.1"},
+		{typeName: "ipaddress", value: "10.0.0", err: "cannot parse ip address"},
+		{typeName: "ipprefix", value: "10.1.2.3/8", want: "10.0.0.0/8"},
+		{typeName: "ipprefix", value: "2001:db8::1/32", want: "2001:db8::/32"},
+		{typeName: "ipprefix", value: "10.0.0.1", err: "cannot parse ip prefix"},
+		{typeName: "interval day to second", value: "-1 00:00:00.250", want: int64(-86400250)},
+		{typeName: "interval day to second", value: "1 day", err: "cannot parse interval day to second"},
+		{typeName: "interval year to month", value: "-2-6", want: int64(-30)},
+		{typeName: "interval year to month", value: "2 years", err: "cannot parse interval year to month"},
+		{typeName: "HyperLogLog", value: "AgwBAIADRAA=", want: "AgwBAIADRAA="},
+		{typeName: "P4HyperLogLog", value: "AwwAAA==", want: "AwwAAA=="},
+		{typeName: "qdigest(bigint)", value: "AK4=", want: "AK4="},
+		{typeName: "tdigest(double)", value: "AQ==", want: "AQ=="},
+		{typeName: "KllSketch(double)", value: "AQ==", want: "AQ=="},
+		{typeName: "SetDigest", value: "AQ==", want: "AQ=="},
+	}
+	converters := Converters(time.UTC)
+	for _, tt := range tests {
+		converter := findConverter(converters, tt.typeName)
+		if converter == nil {
+			t.Errorf("no converter of type %s", tt.typeName)
+			continue
+		}
+		convert := converter.FrameConverter.ConverterFunc
+		got, err := convert(&sql.NullString{String: tt.value, Valid: true})
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("converter of %s(%q) error = %v, want %q", tt.typeName, tt.value, err, tt.err)
+			}
+		} else if err != nil || deref(got) != tt.want {
+			t.Errorf("converter of %s(%q) = %v, %v, want %v", tt.typeName, tt.value, deref(got), err, tt.want)
+		}
+		if got, err := convert(&sql.NullString{}); err != nil || got != nil {
+			t.Errorf("converter of %s(NULL) = %v, %v, want nil", tt.typeName, got, err)
+		}
+	}
+
+	if converter := findConverter(converters, "hyperloglogs"); converter != nil {
+		t.Errorf("type hyperloglogs uses the converter %q", converter.Name)
+	}
+}
+
+func derefString(v interface{}) interface{} {
+	if s, ok := v.(*string); ok {
+		return *s
+	}
+	return v
+}
+
+func deref(v interface{}) interface{} {
+	if i, ok := v.(*int64); ok {
+		return *i
+	}
+	return derefString(v)
+}
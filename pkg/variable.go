@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	defaultVariableMaxValues = 10000
+	// variableCacheTTL is the cache TTL of variable queries without an explicit TTL.
+	variableCacheTTL = time.Minute
+
+	variableTextField  = "__text"
+	variableValueField = "__value"
+)
+
+// Sort orders of template variables, as numbered by Grafana.
+const (
+	variableSortDisabled = iota
+	variableSortAlphabeticalAsc
+	variableSortAlphabeticalDesc
+	variableSortNumericalAsc
+	variableSortNumericalDesc
+	variableSortAlphabeticalCaseInsensitiveAsc
+	variableSortAlphabeticalCaseInsensitiveDesc
+)
+
+var variableNumberPattern = regexp.MustCompile(`.*?(\d+).*`)
+
+type variableValue struct {
+	text  string
+	value string
+}
+
+// variableFrame normalizes the result of a variable query to the fields __text and __value.
+// Results with __text and __value columns keep their pairs, otherwise every value of every column
+// is both text and value. Values are de-duplicated by text, sorted and limited to maxValues.
+func variableFrame(frame *data.Frame, sortOrder int, maxValues int) *data.Frame {
+	var values []variableValue
+	textField, _ := frame.FieldByName(variableTextField)
+	valueField, _ := frame.FieldByName(variableValueField)
+	if textField != nil && valueField != nil {
+		for i := 0; i < textField.Len(); i++ {
+			values = append(values, variableValue{text: variableString(textField, i), value: variableString(valueField, i)})
+		}
+	} else {
+		for _, field := range frame.Fields {
+			for i := 0; i < field.Len(); i++ {
+				s := variableString(field, i)
+				values = append(values, variableValue{text: s, value: s})
+			}
+		}
+	}
+
+	seen := make(map[string]struct{}, len(values))
+	unique := values[:0]
+	for _, v := range values {
+		if _, ok := seen[v.text]; ok {
+			continue
+		}
+		seen[v.text] = struct{}{}
+		unique = append(unique, v)
+	}
+	sortVariableValues(unique, sortOrder)
+
+	result := data.NewFrame(frame.Name,
+		data.NewField(variableTextField, nil, []string{}),
+		data.NewField(variableValueField, nil, []string{}),
+	)
+	result.Meta = frame.Meta
+	if len(unique) > maxValues {
+		result.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Variable values have been limited to %v of %v", maxValues, len(unique)),
+		})
+		unique = unique[:maxValues]
+	}
+	for _, v := range unique {
+		result.AppendRow(v.text, v.value)
+	}
+	return result
+}
+
+// variableString formats a value of a field the way the frontend displays it, null as empty string.
+func variableString(field *data.Field, i int) string {
+	v, ok := field.ConcreteAt(i)
+	if !ok {
+		return ""
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// sortVariableValues sorts the values the same way Grafana sorts the options of a variable.
+func sortVariableValues(values []variableValue, sortOrder int) {
+	var less func(a, b variableValue) bool
+	switch sortOrder {
+	case variableSortAlphabeticalAsc:
+		less = func(a, b variableValue) bool { return a.text < b.text }
+	case variableSortAlphabeticalDesc:
+		less = func(a, b variableValue) bool { return a.text > b.text }
+	case variableSortNumericalAsc:
+		less = func(a, b variableValue) bool { return variableNumber(a.text) < variableNumber(b.text) }
+	case variableSortNumericalDesc:
+		less = func(a, b variableValue) bool { return variableNumber(a.text) > variableNumber(b.text) }
+	case variableSortAlphabeticalCaseInsensitiveAsc:
+		less = func(a, b variableValue) bool { return strings.ToLower(a.text) < strings.ToLower(b.text) }
+	case variableSortAlphabeticalCaseInsensitiveDesc:
+		less = func(a, b variableValue) bool { return strings.ToLower(a.text) > strings.ToLower(b.text) }
+	default:
+		return
+	}
+	sort.SliceStable(values, func(i, j int) bool { return less(values[i], values[j]) })
+}
+
+// variableNumber returns the first number in s, or -1 if there is none.
+func variableNumber(s string) int64 {
+	matches := variableNumberPattern.FindStringSubmatch(s)
+	if len(matches) < 2 {
+		return -1
+	}
+	n, err := strconv.ParseInt(matches[1], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
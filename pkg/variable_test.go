@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// variablePairs returns the __text=__value pairs of a variable frame.
+func variablePairs(t *testing.T, frame *data.Frame) []string {
+	t.Helper()
+	if len(frame.Fields) != 2 || frame.Fields[0].Name != variableTextField || frame.Fields[1].Name != variableValueField {
+		t.Fatalf("variable frame has fields %v, want __text and __value", frame.Fields)
+	}
+	pairs := make([]string, frame.Fields[0].Len())
+	for i := range pairs {
+		pairs[i] = frame.Fields[0].At(i).(string) + "=" + frame.Fields[1].At(i).(string)
+	}
+	return pairs
+}
+
+func TestVariableFrame(t *testing.T) {
+	s := func(v string) *string { return &v }
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name   string
+		frame  *data.Frame
+		sort   int
+		max    int
+		want   string
+		notice string
+	}{
+		{
+			name:  "values of every column",
+			frame: data.NewFrame("", data.NewField("a", nil, []string{"x", "y"}), data.NewField("b", nil, []string{"z"})),
+			want:  "x=x,y=y,z=z",
+		},
+		{
+			name:  "text and value pairs",
+			frame: data.NewFrame("", data.NewField("__value", nil, []string{"1", "2"}), data.NewField("__text", nil, []string{"one", "two"})),
+			want:  "one=1,two=2",
+		},
+		{
+			name:  "text without value is a plain column",
+			frame: data.NewFrame("", data.NewField("__text", nil, []string{"one"}), data.NewField("v", nil, []string{"1"})),
+			want:  "one=one,1=1",
+		},
+		{
+			name:  "de-duplicated by text, the first pair wins",
+			frame: data.NewFrame("", data.NewField("__text", nil, []string{"a", "b", "a"}), data.NewField("__value", nil, []string{"1", "2", "3"})),
+			want:  "a=1,b=2",
+		},
+		{
+			name:  "de-duplicated across columns",
+			frame: data.NewFrame("", data.NewField("a", nil, []string{"x", "y"}), data.NewField("b", nil, []string{"y", "x"})),
+			want:  "x=x,y=y",
+		},
+		{
+			name: "nulls, numbers and times",
+			frame: data.NewFrame("",
+				data.NewField("s", nil, []*string{s("a"), nil}),
+				data.NewField("f", nil, []*float64{f(1.5), f(2)}),
+				data.NewField("t", nil, []time.Time{time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}),
+			),
+			want: "a=a,=,1.5=1.5,2=2,2022-01-01T00:00:00Z=2022-01-01T00:00:00Z",
+		},
+		{
+			name:  "alphabetical",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"b", "C", "a"})),
+			sort:  variableSortAlphabeticalAsc,
+			want:  "C=C,a=a,b=b",
+		},
+		{
+			name:  "alphabetical descending",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"b", "C", "a"})),
+			sort:  variableSortAlphabeticalDesc,
+			want:  "b=b,a=a,C=C",
+		},
+		{
+			name:  "numerical",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"host10", "host9", "none", "host100"})),
+			sort:  variableSortNumericalAsc,
+			want:  "none=none,host9=host9,host10=host10,host100=host100",
+		},
+		{
+			name:  "numerical descending",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"host10", "host9", "host100"})),
+			sort:  variableSortNumericalDesc,
+			want:  "host100=host100,host10=host10,host9=host9",
+		},
+		{
+			name:  "case insensitive",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"b", "C", "a"})),
+			sort:  variableSortAlphabeticalCaseInsensitiveAsc,
+			want:  "a=a,b=b,C=C",
+		},
+		{
+			name:  "case insensitive descending",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"b", "C", "a"})),
+			sort:  variableSortAlphabeticalCaseInsensitiveDesc,
+			want:  "C=C,b=b,a=a",
+		},
+		{
+			name:  "sorted by text, not value",
+			frame: data.NewFrame("", data.NewField("__text", nil, []string{"b", "a"}), data.NewField("__value", nil, []string{"1", "2"})),
+			sort:  variableSortAlphabeticalAsc,
+			want:  "a=2,b=1",
+		},
+		{
+			name:  "unsorted",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"b", "C", "a"})),
+			sort:  variableSortDisabled,
+			want:  "b=b,C=C,a=a",
+		},
+		{
+			name:   "limited after sorting",
+			frame:  data.NewFrame("", data.NewField("v", nil, []string{"c", "b", "a", "b"})),
+			sort:   variableSortAlphabeticalAsc,
+			max:    2,
+			want:   "a=a,b=b",
+			notice: "Variable values have been limited to 2 of 3",
+		},
+		{
+			name:  "exactly the limit",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{"a", "b"})),
+			max:   2,
+			want:  "a=a,b=b",
+		},
+		{
+			name:  "empty",
+			frame: data.NewFrame("", data.NewField("v", nil, []string{})),
+			want:  "",
+		},
+	}
+	for _, tt := range tests {
+		max := tt.max
+		if max == 0 {
+			max = defaultVariableMaxValues
+		}
+		frame := variableFrame(tt.frame, tt.sort, max)
+		if got := strings.Join(variablePairs(t, frame), ","); got != tt.want {
+			t.Errorf("%s: variableFrame() = %s, want %s", tt.name, got, tt.want)
+		}
+		notices := frameNotices(frame)
+		if tt.notice == "" && len(notices) > 0 || tt.notice != "" && (len(notices) != 1 || notices[0] != tt.notice) {
+			t.Errorf("%s: variableFrame() notices = %q, want %q", tt.name, notices, tt.notice)
+		}
+	}
+}
+
+func TestVariableNumber(t *testing.T) {
+	tests := map[string]int64{"42": 42, "host-7a": 7, "v1.2": 1, "none": -1, "": -1}
+	for s, want := range tests {
+		if got := variableNumber(s); got != want {
+			t.Errorf("variableNumber(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import {
   DataQueryResponse,
   DataQueryRequest,
   Field,
+  AnnotationQuery,
 } from '@grafana/data';
 import {
   BackendDataSourceResponse,
@@ -18,14 +19,27 @@ import {
 import { PrestoDataSourceOptions, PrestoQuery } from './types';
 import { map, catchError } from 'rxjs/operators';
 import { lastValueFrom, of, Observable } from 'rxjs';
-import { each } from 'lodash';
+import { each, escapeRegExp } from 'lodash';
 
 export const FORMAT_TIME_SERIES = 'time_series';
 export const FORMAT_TABLE = 'table';
+export const FORMAT_ANNOTATION = 'annotation';
+export const FORMAT_VARIABLE = 'variable';
+
+// Formats of variable references which the backend inserts, see renderVariable.
+const BACKEND_FORMATS = ['singlequote', 'doublequote', 'in', 'array', 'number', 'raw', 'csv'];
 
 export class DataSource extends DataSourceWithBackend<PrestoQuery, PrestoDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<PrestoDataSourceOptions>) {
     super(instanceSettings);
+    this.annotations = {
+      prepareQuery: (anno: AnnotationQuery<PrestoQuery>) => {
+        if (!anno.target) {
+          return undefined;
+        }
+        return { ...anno.target, refId: anno.name, format: FORMAT_ANNOTATION };
+      },
+    };
   }
 
   filterQuery(query: PrestoQuery): boolean {
@@ -75,20 +89,56 @@ export class DataSource extends DataSourceWithBackend<PrestoQuery, PrestoDataSou
     });
   }
 
+  // References to dashboard variables are sent to the backend as ${name} or ${name:format} together
+  // with the selected values, the backend inserts them into the query depending on where they are
+  // referenced, see interpolateVariables. Other variables and references with formats the backend
+  // doesn't know, like ${name:sqlstring} or ${name:regex}, are replaced here.
   applyTemplateVariables(query: PrestoQuery, scopedVars: ScopedVars) {
     const templateSrv = getTemplateSrv();
-    return {
-      ...query,
-      rawSql: query.rawSql ? templateSrv.replace(query.rawSql, scopedVars) : '',
-    };
+    if (!query.rawSql) {
+      return { ...query, rawSql: '' };
+    }
+
+    const templateVariables: { [name: string]: string[] } = {};
+    const references: string[] = [];
+    let rawSql = query.rawSql;
+    each(templateSrv.getVariables(), (variable) => {
+      const name = escapeRegExp(variable.name);
+      const pattern = new RegExp(`\\$${name}\\b|\\$\\{${name}(?::(\\w+))?\\}|\\[\\[${name}(?::(\\w+))?\\]\\]`, 'g');
+      let referenced = false;
+      rawSql = rawSql.replace(pattern, (match, format1, format2) => {
+        const format = format1 || format2;
+        if (format && !BACKEND_FORMATS.includes(format)) {
+          return match;
+        }
+        referenced = true;
+        references.push(format ? `\${${variable.name}:${format}}` : `\${${variable.name}}`);
+        return `\u0000${references.length - 1}\u0000`;
+      });
+      if (referenced) {
+        templateSrv.replace(`\${${variable.name}}`, scopedVars, (value: string | string[]) => {
+          templateVariables[variable.name] = Array.isArray(value) ? value : [value];
+          return '';
+        });
+      }
+    });
+
+    rawSql = templateSrv.replace(rawSql, scopedVars);
+    rawSql = rawSql.replace(/\u0000(\d+)\u0000/g, (_match, idx) => references[Number(idx)]);
+    return { ...query, rawSql, templateVariables };
   }
 
   metricFindQuery(query: string, optionalOptions?: any): Promise<MetricFindValue[]> {
     let refId = 'tempvar';
+    let variableSort = 0;
     if (optionalOptions && optionalOptions.variable && optionalOptions.variable.name) {
       refId = optionalOptions.variable.name;
+      variableSort = optionalOptions.variable.sort || 0;
     }
-    let sql = getTemplateSrv().replace(query, optionalOptions.scopedVars);
+    const { rawSql, templateVariables } = this.applyTemplateVariables(
+      { refId: 'metricFindQuery', rawSql: query } as PrestoQuery,
+      (optionalOptions && optionalOptions.scopedVars) || {}
+    );
     return lastValueFrom(
       getBackendSrv()
         .fetch<BackendDataSourceResponse>({
@@ -99,8 +149,10 @@ export class DataSource extends DataSourceWithBackend<PrestoQuery, PrestoDataSou
               {
                 datasourceId: this.id,
                 refId: 'metricFindQuery',
-                rawSql: sql,
-                format: 'table',
+                rawSql: rawSql,
+                templateVariables: templateVariables,
+                format: FORMAT_VARIABLE,
+                variableSort: variableSort,
               },
             ],
           },
@@ -117,6 +169,7 @@ export class DataSource extends DataSourceWithBackend<PrestoQuery, PrestoDataSou
     );
   }
 
+  // The backend returns the values of variable queries de-duplicated and sorted as __text and __value fields.
   transformMetricFindResponse(raw: FetchResponse<BackendDataSourceResponse>): MetricFindValue[] {
     const frames = toDataQueryResponse(raw).data as DataFrame[];
 
@@ -134,20 +187,8 @@ export class DataSource extends DataSourceWithBackend<PrestoQuery, PrestoDataSou
       for (let i = 0; i < textField.values.length; i++) {
         values.push({ text: '' + textField.values.get(i), value: '' + valueField.values.get(i) });
       }
-    } else {
-      values.push(
-        ...frame.fields
-          .flatMap((f) => f.values.toArray())
-          .map((v) => ({
-            text: v,
-          }))
-      );
     }
-
-    return Array.from(new Set(values.map((v) => v.text))).map((text) => ({
-      text,
-      value: values.find((v) => v.text === text)?.value,
-    }));
+    return values;
   }
 }
 
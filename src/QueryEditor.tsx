@@ -2,7 +2,7 @@ import defaults from 'lodash/defaults';
 
 import React, { PureComponent } from 'react';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
-import { Select, InlineFormLabel } from '@grafana/ui';
+import { Select, InlineFormLabel, InlineSwitch } from '@grafana/ui';
 import { config } from '@grafana/runtime';
 import { DataSource, migrateQuery, FORMAT_TABLE, FORMAT_TIME_SERIES } from './DataSource';
 import { defaultQuery, PrestoDataSourceOptions, PrestoQuery } from './types';
@@ -19,6 +19,29 @@ const FORMAT_OPTIONS: Array<SelectableValue<string>> = [
   { label: 'Table', value: FORMAT_TABLE },
 ];
 
+const DECIMALS_OPTIONS: Array<SelectableValue<string>> = [
+  { label: 'Float', value: 'float' },
+  { label: 'String', value: 'string' },
+  { label: 'Scaled integer', value: 'scaled' },
+];
+
+const FILL_MODE_OPTIONS: Array<SelectableValue<string>> = [
+  { label: 'None', value: '' },
+  { label: 'NULL', value: 'null' },
+  { label: 'Previous', value: 'previous' },
+  { label: 'Value', value: 'value' },
+];
+
+const FILL_AGGREGATION_OPTIONS: Array<SelectableValue<string>> = [
+  { label: 'Last', value: 'last' },
+  { label: 'First', value: 'first' },
+  { label: 'Avg', value: 'avg' },
+  { label: 'Sum', value: 'sum' },
+  { label: 'Min', value: 'min' },
+  { label: 'Max', value: 'max' },
+  { label: 'Count', value: 'count' },
+];
+
 export class QueryEditor extends PureComponent<Props> {
   onQueryChange = (rawSql: string) => {
     const { onChange, query } = this.props;
@@ -41,10 +64,91 @@ export class QueryEditor extends PureComponent<Props> {
     onChange({ ...query, legendFormat: e.currentTarget.value });
   };
 
+  onFillModeChange = (option: SelectableValue<string>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, fill: !!option.value, fillMode: option.value });
+  };
+
+  onFillAggregationChange = (option: SelectableValue<string>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, fillAggregation: option.value });
+  };
+
+  onFillIntervalChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const fillInterval = Number(e.currentTarget.value);
+    onChange({ ...query, fillInterval: isNaN(fillInterval) ? undefined : fillInterval });
+  };
+
+  onFillValueChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const fillValue = Number(e.currentTarget.value);
+    onChange({ ...query, fillValue: isNaN(fillValue) ? undefined : fillValue });
+  };
+
+  onSessionPropertiesChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    const sessionProperties: { [name: string]: string } = {};
+    e.currentTarget.value.split(',').forEach((property) => {
+      const idx = property.indexOf('=');
+      if (idx > 0) {
+        sessionProperties[property.slice(0, idx).trim()] = property.slice(idx + 1).trim();
+      }
+    });
+    onChange({ ...query, sessionProperties });
+    onRunQuery();
+  };
+
+  onClientTagsChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    const clientTags = e.currentTarget.value
+      .split(',')
+      .map((tag) => tag.trim())
+      .filter((tag) => tag !== '');
+    onChange({ ...query, clientTags });
+    onRunQuery();
+  };
+
+  onCacheTTLChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const cacheTTLSeconds = Number(e.currentTarget.value);
+    onChange({ ...query, cacheTTLSeconds: isNaN(cacheTTLSeconds) || cacheTTLSeconds === 0 ? undefined : cacheTTLSeconds });
+  };
+
+  onRowLimitChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    const rowLimit = Number(e.currentTarget.value);
+    onChange({ ...query, rowLimit: isNaN(rowLimit) || rowLimit <= 0 ? undefined : rowLimit });
+  };
+
+  onTimeColumnChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, timeColumn: e.currentTarget.value.trim() || undefined });
+  };
+
+  onTimeFormatChange = (e: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, timeFormat: e.currentTarget.value || undefined });
+  };
+
+  onDecimalsChange = (option: SelectableValue<string>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, decimals: option.value });
+  };
+
+  onExplodeChange = (e: React.FormEvent<HTMLInputElement>) => {
+    const { onChange, query, onRunQuery } = this.props;
+    onChange({ ...query, explode: e.currentTarget.checked });
+    onRunQuery();
+  };
+
   render() {
     const query = defaults(this.props.query, defaultQuery);
     migrateQuery(query);
-    const { rawSql, format, legendFormat } = query;
+    const { rawSql, format, legendFormat, fill, fillMode, fillInterval, fillValue, fillAggregation } = query;
+    const sessionProperties = Object.entries(query.sessionProperties || {})
+      .map(([name, value]) => `${name}=${value}`)
+      .join(',');
     return (
       <div>
         <div className="gf-form">
@@ -98,7 +202,182 @@ export class QueryEditor extends PureComponent<Props> {
             onBlur={this.onQueryBlur}
             value={format}
           />
+          <InlineFormLabel
+            className="gf-form-label width-7"
+            tooltip="Replaces map(varchar, ...) and row columns by a column per key. Keys of numeric values become values, the other keys labels of time series."
+          >
+            Explode
+          </InlineFormLabel>
+          <InlineSwitch value={!!query.explode} onChange={this.onExplodeChange} />
+        </div>
+        <div className="gf-form">
+          <InlineFormLabel
+            className="gf-form-label width-7"
+            tooltip="Session properties of this query, e.g. join_distribution_type=BROADCAST. Only properties allowed by the datasource can be set."
+          >
+            Session
+          </InlineFormLabel>
+          <input
+            type="text"
+            className="gf-form-input width-24"
+            placeholder="name=value,name=value"
+            defaultValue={sessionProperties}
+            onBlur={this.onSessionPropertiesChange}
+          />
+          <div className="gf-form-label width-7">Client tags</div>
+          <input
+            type="text"
+            className="gf-form-input width-16"
+            placeholder="tag,tag"
+            defaultValue={(query.clientTags || []).join(',')}
+            onBlur={this.onClientTagsChange}
+          />
+          <InlineFormLabel
+            className="gf-form-label width-7"
+            tooltip="Seconds the result of this query is cached, defaults to the cache TTL of the datasource. -1 disables the cache."
+          >
+            Cache TTL
+          </InlineFormLabel>
+          <input
+            type="number"
+            className="gf-form-input width-8"
+            placeholder="default"
+            value={query.cacheTTLSeconds || ''}
+            onChange={this.onCacheTTLChange}
+            onBlur={this.onQueryBlur}
+          />
+          <InlineFormLabel
+            className="gf-form-label width-7"
+            tooltip="Max rows of the result of this query, it can only be lower than the row limits of the datasource."
+          >
+            Row limit
+          </InlineFormLabel>
+          <input
+            type="number"
+            className="gf-form-input width-8"
+            placeholder="default"
+            value={query.rowLimit || ''}
+            onChange={this.onRowLimitChange}
+            onBlur={this.onQueryBlur}
+          />
+        </div>
+        <div className="gf-form">
+          <InlineFormLabel
+            className="gf-form-label width-7"
+            tooltip="Name of the time column. Defaults to a column named time or time_sec, else the first timestamp or date column."
+          >
+            Time column
+          </InlineFormLabel>
+          <input
+            type="text"
+            className="gf-form-input width-16"
+            placeholder="auto"
+            value={query.timeColumn || ''}
+            onChange={this.onTimeColumnChange}
+            onBlur={this.onQueryBlur}
+          />
+          <InlineFormLabel
+            className="gf-form-label width-7"
+            tooltip="Format of a string time column: a Go layout like 2006-01-02 15:04:05, a MySQL format like %Y-%m-%d %H:%i:%s or a Java pattern like yyyy-MM-dd HH:mm:ss."
+          >
+            Time format
+          </InlineFormLabel>
+          <input
+            type="text"
+            className="gf-form-input width-16"
+            placeholder="auto"
+            value={query.timeFormat || ''}
+            onChange={this.onTimeFormatChange}
+            onBlur={this.onQueryBlur}
+          />
         </div>
+        {format === FORMAT_TABLE && (
+          <div className="gf-form">
+            <InlineFormLabel
+              className="gf-form-label width-7"
+              tooltip="How decimal columns are returned. Floats may lose precision, strings and integers scaled by 10^scale are exact."
+            >
+              Decimals
+            </InlineFormLabel>
+            <Select
+              menuShouldPortal
+              className="select-container"
+              width={16}
+              isSearchable={false}
+              options={DECIMALS_OPTIONS}
+              onChange={this.onDecimalsChange}
+              onBlur={this.onQueryBlur}
+              value={query.decimals || 'float'}
+            />
+          </div>
+        )}
+        {format === FORMAT_TIME_SERIES && (
+          <div className="gf-form">
+            <InlineFormLabel
+              className="gf-form-label width-7"
+              tooltip="Fills the gaps of the time series with NULL, the previous value or a fixed value."
+            >
+              Fill
+            </InlineFormLabel>
+            <Select
+              menuShouldPortal
+              className="select-container"
+              width={16}
+              isSearchable={false}
+              options={FILL_MODE_OPTIONS}
+              onChange={this.onFillModeChange}
+              onBlur={this.onQueryBlur}
+              value={fill ? fillMode || 'null' : ''}
+            />
+            {fill && (
+              <>
+                <InlineFormLabel
+                  className="gf-form-label width-7"
+                  tooltip="Fill interval in seconds, defaults to the panel interval."
+                >
+                  Interval
+                </InlineFormLabel>
+                <input
+                  type="number"
+                  className="gf-form-input width-8"
+                  placeholder="auto"
+                  value={fillInterval || ''}
+                  onChange={this.onFillIntervalChange}
+                  onBlur={this.onQueryBlur}
+                />
+                <InlineFormLabel
+                  className="gf-form-label width-7"
+                  tooltip="How the values within one interval are combined."
+                >
+                  Aggregation
+                </InlineFormLabel>
+                <Select
+                  menuShouldPortal
+                  className="select-container"
+                  width={12}
+                  isSearchable={false}
+                  options={FILL_AGGREGATION_OPTIONS}
+                  onChange={this.onFillAggregationChange}
+                  onBlur={this.onQueryBlur}
+                  value={fillAggregation || 'last'}
+                />
+              </>
+            )}
+            {fill && fillMode === 'value' && (
+              <>
+                <div className="gf-form-label width-7">Value</div>
+                <input
+                  type="number"
+                  className="gf-form-input width-8"
+                  placeholder="0"
+                  value={fillValue ?? ''}
+                  onChange={this.onFillValueChange}
+                  onBlur={this.onQueryBlur}
+                />
+              </>
+            )}
+          </div>
+        )}
       </div>
     );
   }
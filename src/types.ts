@@ -6,6 +6,21 @@ export interface PrestoQuery extends DataQuery {
   legendFormat: string;
   queryText?: string;
   queryType?: string;
+  fill?: boolean;
+  fillInterval?: number;
+  fillMode?: string;
+  fillValue?: number;
+  fillAggregation?: string;
+  sessionProperties?: { [name: string]: string };
+  clientTags?: string[];
+  cacheTTLSeconds?: number;
+  variableSort?: number;
+  rowLimit?: number;
+  explode?: boolean;
+  decimals?: string;
+  timeColumn?: string;
+  timeFormat?: string;
+  templateVariables?: { [name: string]: string[] };
 }
 
 export const defaultQuery: Partial<PrestoQuery> = {
@@ -24,10 +39,52 @@ export interface PrestoDataSourceOptions extends DataSourceJsonData {
   queryMaxExecutionSeconds?: number;
   rowLimit?: number;
   resultRowLimit?: number;
+  resultMaxBytes?: number;
   customParams: CustomParam[];
+  authType?: string;
+  allowInsecureAuth?: boolean;
+  tlsSkipVerify?: boolean;
+  tlsAuth?: boolean;
+  tlsAuthWithCACert?: boolean;
+  oauth2TokenURL?: string;
+  oauth2Scopes?: string;
+  forwardUser?: boolean;
+  forwardUserAsClientInfo?: boolean;
+  userAllowlistOnly?: boolean;
+  serviceUser?: string;
+  userMappings?: UserMapping[];
+  allowedSessionProperties?: string[];
+  cacheTTLSeconds?: number;
+  cacheMaxBytes?: number;
+  maxConcurrentQueries?: number;
+  queueTimeoutSeconds?: number;
+  maxOpenConns?: number;
+  maxIdleConns?: number;
+  connMaxLifetime?: number;
+  variableMaxValues?: number;
+  readOnly?: boolean;
+  sessionTimeZone?: string;
+}
+
+/**
+ * Values that are used in the backend, but never sent over HTTP to the frontend
+ */
+export interface PrestoSecureJsonData {
+  password?: string;
+  token?: string;
+  clientId?: string;
+  clientSecret?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
 }
 
 export interface CustomParam {
   name: string;
   value: string;
 }
+
+export interface UserMapping {
+  grafanaUser: string;
+  prestoUser: string;
+}